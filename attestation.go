@@ -0,0 +1,87 @@
+package kubeauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/briankassouf/jose/crypto"
+	"github.com/briankassouf/jose/jws"
+)
+
+// attestationTTL is how long the attestation of a login is valid for, it is
+// meant to be verified as the client presents it, not kept.
+const attestationTTL = 5 * time.Minute
+
+// attestationIssuer is the iss claim of the attestations.
+const attestationIssuer = "vault-plugin-auth-kubernetes"
+
+// parseSigningKeyPEM parses an RSA or ECDSA private key from a PEM, in PKCS #1,
+// SEC 1 or PKCS #8 form, and returns it with the method signing with it.
+func parseSigningKeyPEM(data []byte) (interface{}, crypto.SigningMethod, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("data does not contain a PEM block")
+	}
+
+	var key interface{}
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, nil, errors.New("data does not contain a valid RSA or ECDSA private key")
+			}
+		}
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, crypto.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return key, crypto.SigningMethodES256, nil
+		case elliptic.P384():
+			return key, crypto.SigningMethodES384, nil
+		case elliptic.P521():
+			return key, crypto.SigningMethodES512, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+	}
+	return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// signAttestation returns a JWT signed with the attestation signing key of the
+// config, attesting that the service account with the given uid logged in with
+// the role at now.
+func signAttestation(config *kubeConfig, roleName string, sa *serviceAccount, uid string, now time.Time) (string, error) {
+	key, method, err := parseSigningKeyPEM([]byte(config.AttestationSigningKey))
+	if err != nil {
+		return "", err
+	}
+
+	claims := jws.Claims{}
+	claims.SetIssuer(attestationIssuer)
+	claims.SetSubject(fmt.Sprintf("system:serviceaccount:%s:%s", sa.namespace(), sa.name()))
+	claims.SetIssuedAt(now)
+	claims.SetNotBefore(now)
+	claims.SetExpiration(now.Add(attestationTTL))
+	claims.Set("namespace", sa.namespace())
+	claims.Set("service_account_name", sa.name())
+	claims.Set("service_account_uid", uid)
+	claims.Set("role", roleName)
+	if config.ClusterName != "" {
+		claims.Set("cluster_name", config.ClusterName)
+	}
+
+	token, err := jws.NewJWT(claims, method).Serialize(key)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
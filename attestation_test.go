@@ -0,0 +1,179 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/briankassouf/jose/crypto"
+	"github.com/briankassouf/jose/jws"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testAttestationKey signs the attestations in tests, testAttestationKeyPEM is
+// its PKCS #8 form for use in attestation_signing_key.
+var testAttestationKey, testAttestationKeyPEM = newTestAttestationKey()
+
+func newTestAttestationKey() (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestParseSigningKeyPEM(t *testing.T) {
+	ecDER, err := x509.MarshalECPrivateKey(testAttestationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		pem        string
+		wantMethod crypto.SigningMethod
+		wantErr    bool
+	}{
+		"pkcs8 ecdsa": {
+			pem:        testAttestationKeyPEM,
+			wantMethod: crypto.SigningMethodES256,
+		},
+		"sec1 ecdsa": {
+			pem:        string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})),
+			wantMethod: crypto.SigningMethodES256,
+		},
+		"pkcs1 rsa": {
+			pem:        string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testSigningKey)})),
+			wantMethod: crypto.SigningMethodRS256,
+		},
+		"public key": {
+			pem:     testSigningKeyPEM,
+			wantErr: true,
+		},
+		"not a pem": {
+			pem:     "key",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, method, err := parseSigningKeyPEM([]byte(tc.pem))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method != tc.wantMethod {
+				t.Fatalf("expected %s, got %s", tc.wantMethod.Alg(), method.Alg())
+			}
+		})
+	}
+}
+
+func TestConfig_AttestationSigningKey(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host":         "host",
+			"kubernetes_ca_cert":      testCACert,
+			"attestation_signing_key": testSigningKeyPEM,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected the public key to be rejected, got %#v", resp)
+	}
+	if want := "invalid attestation_signing_key: data does not contain a valid RSA or ECDSA private key"; resp.Error().Error() != want {
+		t.Fatalf("expected %q, got %q", want, resp.Error())
+	}
+}
+
+func TestLoginAttestation(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":                testDefaultPEMs,
+			"kubernetes_host":         "host",
+			"kubernetes_ca_cert":      testCACert,
+			"cluster_name":            "prod",
+			"attestation_signing_key": testAttestationKeyPEM,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	before := time.Now().Truncate(time.Second)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "plugin-test",
+			"jwt":  jwtData,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	attestation, ok := resp.Data["attestation"].(string)
+	if !ok {
+		t.Fatalf("expected an attestation, got %#v", resp.Data)
+	}
+	token, err := jws.ParseJWT([]byte(attestation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Validate(&testAttestationKey.PublicKey, crypto.SigningMethodES256); err != nil {
+		t.Fatalf("expected the attestation to verify with the signing key, got %v", err)
+	}
+
+	claims := token.Claims()
+	for claim, want := range map[string]interface{}{
+		"iss":                  attestationIssuer,
+		"sub":                  "system:serviceaccount:default:vault-auth",
+		"namespace":            "default",
+		"service_account_name": "vault-auth",
+		"service_account_uid":  testUID,
+		"role":                 "plugin-test",
+		"cluster_name":         "prod",
+	} {
+		if got := claims.Get(claim); got != want {
+			t.Fatalf("expected claim %s to be %v, got %v", claim, want, got)
+		}
+	}
+	iat, ok := claims.IssuedAt()
+	if !ok || iat.Before(before) || iat.After(time.Now()) {
+		t.Fatalf("expected the attestation to be issued at the login, got %v", iat)
+	}
+	if exp, ok := claims.Expiration(); !ok || !exp.Equal(iat.Add(attestationTTL)) {
+		t.Fatalf("expected the attestation to expire after %s, got %v", attestationTTL, exp)
+	}
+}
@@ -0,0 +1,52 @@
+package kubeauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// auditIDHeader is the header the apiserver identifies its audit events for
+// a request with.
+const auditIDHeader = "Audit-Id"
+
+// auditIDsKey is the context key of the auditIDs collected for a login.
+type auditIDsKey struct{}
+
+// auditIDs collects the audit ids of the kubernetes API responses received
+// while handling a login, in the order they were received.
+type auditIDs struct {
+	l   sync.Mutex
+	ids []string
+}
+
+// withAuditIDs returns a context collecting the audit ids of the kubernetes
+// API responses received with it.
+func withAuditIDs(ctx context.Context) (context.Context, *auditIDs) {
+	ids := &auditIDs{}
+	return context.WithValue(ctx, auditIDsKey{}, ids), ids
+}
+
+// recordAuditID adds the audit id of resp to the auditIDs of ctx, if any.
+func recordAuditID(ctx context.Context, resp *http.Response) {
+	ids, ok := ctx.Value(auditIDsKey{}).(*auditIDs)
+	if !ok {
+		return
+	}
+	id := strings.TrimSpace(resp.Header.Get(auditIDHeader))
+	if id == "" {
+		return
+	}
+
+	ids.l.Lock()
+	defer ids.l.Unlock()
+	ids.ids = append(ids.ids, id)
+}
+
+// String returns the collected audit ids, comma separated.
+func (a *auditIDs) String() string {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return strings.Join(a.ids, ",")
+}
@@ -2,13 +2,17 @@ package kubeauth
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -21,14 +25,73 @@ const (
 	aliasNameSourceUnset   = ""
 	aliasNameSourceSAUid   = "serviceaccount_uid"
 	aliasNameSourceSAName  = "serviceaccount_name"
+	aliasNameSourceClaim   = "claim"
 	aliasNameSourceDefault = aliasNameSourceSAUid
+
+	verificationPrecedenceReviewWins    = "review_wins"
+	verificationPrecedenceSignatureWins = "signature_wins"
+	verificationPrecedenceBothRequired  = "both_required"
+	verificationPrecedenceDefault       = verificationPrecedenceBothRequired
+
+	metadataOverflowTruncate = "truncate"
+	metadataOverflowFail     = "fail"
+	metadataOverflowDefault  = metadataOverflowTruncate
+
+	saNotFoundMetadataIgnore  = "ignore"
+	saNotFoundMetadataFail    = "fail"
+	saNotFoundMetadataDefault = saNotFoundMetadataFail
+
+	tokenReviewAPIVersionV1      = "v1"
+	tokenReviewAPIVersionV1beta1 = "v1beta1"
+	tokenReviewAPIVersionDefault = tokenReviewAPIVersionV1
+
+	annotationCollisionError     = "error"
+	annotationCollisionFirstWins = "first_wins"
+	annotationCollisionLastWins  = "last_wins"
+	annotationCollisionDefault   = annotationCollisionError
+
+	claimParseOrderProjectedFirst = "projected_first"
+	claimParseOrderLegacyFirst    = "legacy_first"
+	claimParseOrderDefault        = claimParseOrderProjectedFirst
+
+	tokenReviewUnreachableFail     = "fail"
+	tokenReviewUnreachableFailOpen = "fail_open"
+	tokenReviewUnreachableDefault  = tokenReviewUnreachableFail
 )
 
 var (
 	// when adding new alias name sources make sure to update the corresponding FieldSchema description in path_role.go
-	aliasNameSources          = []string{aliasNameSourceSAUid, aliasNameSourceSAName}
+	aliasNameSources          = []string{aliasNameSourceSAUid, aliasNameSourceSAName, aliasNameSourceClaim}
 	errInvalidAliasNameSource = fmt.Errorf(`invalid alias_name_source, must be one of: %s`, strings.Join(aliasNameSources, ", "))
 
+	// when adding new verification precedences make sure to update the corresponding FieldSchema description in path_config.go
+	verificationPrecedences          = []string{verificationPrecedenceReviewWins, verificationPrecedenceSignatureWins, verificationPrecedenceBothRequired}
+	errInvalidVerificationPrecedence = fmt.Errorf(`invalid verification_precedence, must be one of: %s`, strings.Join(verificationPrecedences, ", "))
+
+	// when adding new metadata overflow modes make sure to update the corresponding FieldSchema description in path_config.go
+	metadataOverflows          = []string{metadataOverflowTruncate, metadataOverflowFail}
+	errInvalidMetadataOverflow = fmt.Errorf(`invalid max_metadata_overflow, must be one of: %s`, strings.Join(metadataOverflows, ", "))
+
+	// when adding new service account not found modes make sure to update the corresponding FieldSchema description in path_config.go
+	saNotFoundMetadataModes          = []string{saNotFoundMetadataIgnore, saNotFoundMetadataFail}
+	errInvalidSANotFoundMetadataMode = fmt.Errorf(`invalid sa_not_found_metadata_mode, must be one of: %s`, strings.Join(saNotFoundMetadataModes, ", "))
+
+	// when adding new TokenReview API versions make sure to update the corresponding FieldSchema description in path_config.go
+	tokenReviewAPIVersions          = []string{tokenReviewAPIVersionV1, tokenReviewAPIVersionV1beta1}
+	errInvalidTokenReviewAPIVersion = fmt.Errorf(`invalid token_review_api_version, must be one of: %s`, strings.Join(tokenReviewAPIVersions, ", "))
+
+	// when adding new annotation collision modes make sure to update the corresponding FieldSchema description in path_config.go
+	annotationCollisionModes          = []string{annotationCollisionError, annotationCollisionFirstWins, annotationCollisionLastWins}
+	errInvalidAnnotationCollisionMode = fmt.Errorf(`invalid annotation_collision_mode, must be one of: %s`, strings.Join(annotationCollisionModes, ", "))
+
+	// when adding new claim parse orders make sure to update the corresponding FieldSchema description in path_config.go
+	claimParseOrders          = []string{claimParseOrderProjectedFirst, claimParseOrderLegacyFirst}
+	errInvalidClaimParseOrder = fmt.Errorf(`invalid claim_parse_order, must be one of: %s`, strings.Join(claimParseOrders, ", "))
+
+	// when adding new TokenReview unreachable modes make sure to update the corresponding FieldSchema description in path_config.go
+	tokenReviewUnreachableModes          = []string{tokenReviewUnreachableFail, tokenReviewUnreachableFailOpen}
+	errInvalidTokenReviewUnreachableMode = fmt.Errorf(`invalid token_review_unreachable_mode, must be one of: %s`, strings.Join(tokenReviewUnreachableModes, ", "))
+
 	// jwtReloadPeriod is the time period how often the in-memory copy of local
 	// service account token can be used, before reading it again from disk.
 	//
@@ -40,6 +103,10 @@ var (
 	// caReloadPeriod is the time period how often the in-memory copy of local
 	// CA cert can be used, before reading it again from disk.
 	caReloadPeriod = 1 * time.Hour
+
+	// pemKeysDirReloadPeriod is the time period how often the in-memory copy of
+	// the keys in pem_keys_dir can be used, before scanning the directory again.
+	pemKeysDirReloadPeriod = 1 * time.Minute
 )
 
 // kubeAuthBackend implements logical.Backend
@@ -54,6 +121,29 @@ type kubeAuthBackend struct {
 	// serviceAccountReaderFactory is used to read service account annotations
 	serviceAccountReaderFactory serviceAccountReaderFactory
 
+	// podReaderFactory is used to read the owners of pods for roles setting
+	// bound_owner_references.
+	podReaderFactory podReaderFactory
+
+	// namespaceListerFactory is used to list the namespaces the roles are
+	// reconciled against.
+	namespaceListerFactory namespaceListerFactory
+
+	// namespaceReaderFactory is used to read the namespace labels of roles
+	// setting enable_namespace_metadata, and the namespaces of tokens when
+	// validate_namespace_exists is set.
+	namespaceReaderFactory namespaceReaderFactory
+
+	// namespaces caches the namespaces found to exist.
+	namespaces *namespaceCache
+
+	// podOwners caches the owners resolved for pods.
+	podOwners *podOwnerCache
+
+	// tokenAddrs binds projected tokens to the remote address they were
+	// first used from, see bind_token_to_remote_addr.
+	tokenAddrs *tokenAddrCache
+
 	// localSATokenReader caches the service account token in memory.
 	// It periodically reloads the token to support token rotation/renewal.
 	// Local token is used when running in a pod with following configuration
@@ -67,6 +157,34 @@ type kubeAuthBackend struct {
 	// - disable_local_ca_jwt is false
 	localCACertReader *cachingFileReader
 
+	// pemKeysDirReader caches the public keys loaded from pem_keys_dir, it is
+	// created on first use and replaced whenever the configured directory
+	// changes. It is guarded by pemKeysDirLock.
+	pemKeysDirReader *cachingKeyDirReader
+	pemKeysDirLock   sync.Mutex
+
+	// namespacePrefixStrip caches the compiled namespace_prefix_strip
+	// pattern, it is recompiled only when the configured pattern changes. It
+	// is guarded by namespacePrefixStripLock.
+	namespacePrefixStrip     *regexp.Regexp
+	namespacePrefixStripLock sync.Mutex
+
+	// loginSemaphores limit the concurrent logins of the roles setting
+	// max_concurrent_logins, keyed by role name, and are dropped when the
+	// role is deleted. They are guarded by loginSemaphoresLock.
+	loginSemaphores     map[string]chan struct{}
+	loginSemaphoresLock sync.Mutex
+
+	// globalLoginSem limits the concurrent logins across all roles when
+	// global_login_concurrency is set. It is guarded by loginSemaphoresLock.
+	globalLoginSem *loginSemaphore
+
+	// caCerts caches the certificates parsed from caCertsPEM, the CA bundle
+	// last checked by checkCACertValidity. They are guarded by caCertsLock.
+	caCerts     []*x509.Certificate
+	caCertsPEM  string
+	caCertsLock sync.Mutex
+
 	l sync.RWMutex
 }
 
@@ -83,12 +201,18 @@ func Backend() *kubeAuthBackend {
 	b := &kubeAuthBackend{
 		localSATokenReader: newCachingFileReader(localJWTPath, jwtReloadPeriod, time.Now),
 		localCACertReader:  newCachingFileReader(localCACertPath, caReloadPeriod, time.Now),
+		loginSemaphores:    make(map[string]chan struct{}),
+		podOwners:          newPodOwnerCache(time.Now),
+		tokenAddrs:         newTokenAddrCache(time.Now, tokenAddrCacheMaxEntries),
+		namespaces:         newNamespaceCache(time.Now),
 	}
 
 	b.Backend = &framework.Backend{
-		AuthRenew:   b.pathLoginRenew(),
-		BackendType: logical.TypeCredential,
-		Help:        backendHelp,
+		AuthRenew:      b.pathLoginRenew(),
+		BackendType:    logical.TypeCredential,
+		Help:           backendHelp,
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
 				"login",
@@ -100,7 +224,11 @@ func Backend() *kubeAuthBackend {
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				pathConfig(b),
+				pathConfigCheckRBAC(b),
+				pathConfigClockSkew(b),
 				pathLogin(b),
+				pathCacheStats(b),
+				pathRolesStale(b),
 			},
 			pathsRole(b),
 		),
@@ -109,6 +237,9 @@ func Backend() *kubeAuthBackend {
 	// Set the review factory to default to calling into the kubernetes API.
 	b.reviewFactory = tokenReviewAPIFactory
 	b.serviceAccountReaderFactory = serviceAccountAPIFactory
+	b.podReaderFactory = podAPIFactory
+	b.namespaceListerFactory = namespaceAPIFactory
+	b.namespaceReaderFactory = namespaceReaderAPIFactory
 
 	return b
 }
@@ -124,8 +255,8 @@ func (b *kubeAuthBackend) config(ctx context.Context, s logical.Storage) (*kubeC
 		return nil, nil
 	}
 
-	conf := &kubeConfig{}
-	if err := json.Unmarshal(raw.Value, conf); err != nil {
+	conf, _, err := upgradeConfig(raw.Value)
+	if err != nil {
 		return nil, err
 	}
 
@@ -138,6 +269,13 @@ func (b *kubeAuthBackend) config(ctx context.Context, s logical.Storage) (*kubeC
 		}
 	}
 
+	if conf.NamespacePrefixStrip != "" {
+		conf.NamespacePrefixStripRegexp, err = b.compileNamespacePrefixStrip(conf.NamespacePrefixStrip)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return conf, nil
 }
 
@@ -152,6 +290,20 @@ func (b *kubeAuthBackend) loadConfig(ctx context.Context, s logical.Storage) (*k
 		return nil, errors.New("could not load backend configuration")
 	}
 
+	// Load the keys in pem_keys_dir in addition to the ones stored in config.
+	if config.PEMKeysDir != "" {
+		keys, err := b.readPEMKeysDir(config.PEMKeysDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pem_keys_dir: %v", err)
+		}
+		config.PublicKeys = append(config.PublicKeys, keys...)
+
+		// Don't silently fall back to skipping the signature verification.
+		if len(config.PublicKeys) == 0 {
+			return nil, errors.New("no valid public keys found in pem_keys_dir")
+		}
+	}
+
 	// Nothing more to do if loading local CA cert and JWT token is disabled.
 	if config.DisableLocalCAJwt {
 		return config, nil
@@ -178,6 +330,76 @@ func (b *kubeAuthBackend) loadConfig(ctx context.Context, s logical.Storage) (*k
 	return config, nil
 }
 
+// readPEMKeysDir returns the public keys found in dir, reusing the cached keys
+// as long as the configured directory is unchanged.
+func (b *kubeAuthBackend) readPEMKeysDir(dir string) ([]interface{}, error) {
+	b.pemKeysDirLock.Lock()
+	if b.pemKeysDirReader == nil || b.pemKeysDirReader.path != dir {
+		b.pemKeysDirReader = newCachingKeyDirReader(dir, pemKeysDirReloadPeriod, time.Now, b.Logger())
+	}
+	r := b.pemKeysDirReader
+	b.pemKeysDirLock.Unlock()
+
+	return r.ReadKeys()
+}
+
+// compileNamespacePrefixStrip returns the compiled namespace_prefix_strip
+// pattern, reusing the cached one as long as the pattern is unchanged.
+func (b *kubeAuthBackend) compileNamespacePrefixStrip(pattern string) (*regexp.Regexp, error) {
+	b.namespacePrefixStripLock.Lock()
+	defer b.namespacePrefixStripLock.Unlock()
+
+	if b.namespacePrefixStrip == nil || b.namespacePrefixStrip.String() != pattern {
+		re, err := compileNamespacePrefixStrip(pattern)
+		if err != nil {
+			return nil, err
+		}
+		b.namespacePrefixStrip = re
+	}
+	return b.namespacePrefixStrip, nil
+}
+
+// acquireLoginSlot blocks until one of the limit concurrent logins allowed for
+// the role is available. If the context has no deadline it fails immediately
+// rather than waiting. The returned function releases the slot.
+func (b *kubeAuthBackend) acquireLoginSlot(ctx context.Context, roleName string, limit int) (func(), error) {
+	b.loginSemaphoresLock.Lock()
+	sem, ok := b.loginSemaphores[roleName]
+	if !ok || cap(sem) != limit {
+		sem = make(chan struct{}, limit)
+		b.loginSemaphores[roleName] = sem
+	}
+	b.loginSemaphoresLock.Unlock()
+
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	errTooMany := newLoginError(http.StatusTooManyRequests, reasonTooManyConcurrentLogins, errors.New("too many concurrent logins"))
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errTooMany
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, errTooMany
+	}
+}
+
+// dropLoginSemaphore forgets the concurrent login limit of the deleted role.
+// Logins still holding a slot release it on the dropped semaphore.
+func (b *kubeAuthBackend) dropLoginSemaphore(roleName string) {
+	b.loginSemaphoresLock.Lock()
+	delete(b.loginSemaphores, roleName)
+	b.loginSemaphoresLock.Unlock()
+}
+
 // role takes a storage backend and the name and returns the role's storage
 // entry
 func (b *kubeAuthBackend) role(ctx context.Context, s logical.Storage, name string) (*roleStorageEntry, error) {
@@ -189,8 +411,8 @@ func (b *kubeAuthBackend) role(ctx context.Context, s logical.Storage, name stri
 		return nil, nil
 	}
 
-	role := &roleStorageEntry{}
-	if err := json.Unmarshal(raw.Value, role); err != nil {
+	role, _, err := upgradeRole(raw.Value)
+	if err != nil {
 		return nil, err
 	}
 
@@ -216,13 +438,176 @@ func (b *kubeAuthBackend) role(ctx context.Context, s logical.Storage, name stri
 	return role, nil
 }
 
-func validateAliasNameSource(source string) error {
-	for _, s := range aliasNameSources {
-		if s == source {
+// checkCACertValidity returns an error if none of the certificates of the CA
+// bundle are valid at now, which would otherwise fail the TLS handshakes with
+// the kubernetes API with a generic error. Bundles which can't be parsed are
+// left to fail the handshake.
+func (b *kubeAuthBackend) checkCACertValidity(caPEM string, now time.Time) error {
+	if caPEM == "" {
+		return nil
+	}
+
+	b.caCertsLock.Lock()
+	if b.caCertsPEM != caPEM {
+		b.caCerts = parseCertificatesPEM([]byte(caPEM))
+		b.caCertsPEM = caPEM
+	}
+	certs := b.caCerts
+	b.caCertsLock.Unlock()
+
+	if len(certs) == 0 {
+		return nil
+	}
+
+	notYetValid := false
+	for _, cert := range certs {
+		switch {
+		case now.Before(cert.NotBefore):
+			notYetValid = true
+		case now.After(cert.NotAfter):
+		default:
 			return nil
 		}
 	}
-	return errInvalidAliasNameSource
+
+	if notYetValid {
+		return newLoginError(http.StatusInternalServerError, reasonCANotYetValid, errors.New("configured kubernetes CA not yet valid"))
+	}
+	return newLoginError(http.StatusInternalServerError, reasonCAExpired, errors.New("configured kubernetes CA expired"))
+}
+
+// parseCertificatesPEM returns the certificates of the PEM bundle, skipping
+// the blocks which are not valid certificates.
+func parseCertificatesPEM(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// roleByAlias resolves name through the configured role_aliases. It returns
+// the target role and its name, or a nil role if name is not an alias or its
+// target does not exist.
+func (b *kubeAuthBackend) roleByAlias(ctx context.Context, s logical.Storage, name string) (*roleStorageEntry, string, error) {
+	config, err := b.config(ctx, s)
+	if err != nil || config == nil {
+		return nil, "", err
+	}
+
+	target, ok := config.RoleAliases[strings.ToLower(name)]
+	if !ok {
+		return nil, "", nil
+	}
+
+	role, err := b.role(ctx, s, target)
+	if err != nil {
+		return nil, "", err
+	}
+	return role, target, nil
+}
+
+// normaliseRoleAliases lowercases role aliases as role names are, and rejects
+// aliases resolving to themselves or to other aliases.
+func normaliseRoleAliases(aliases map[string]string) (map[string]string, error) {
+	normalised := make(map[string]string, len(aliases))
+	for alias, target := range aliases {
+		alias, target = strings.ToLower(strings.TrimSpace(alias)), strings.ToLower(strings.TrimSpace(target))
+		if alias == "" || target == "" {
+			return nil, errors.New("role_aliases can not contain empty role names")
+		}
+		if alias == target {
+			return nil, fmt.Errorf("role alias %q can not refer to itself", alias)
+		}
+		normalised[alias] = target
+	}
+	for alias, target := range normalised {
+		if _, ok := normalised[target]; ok {
+			return nil, fmt.Errorf("role alias %q can not refer to another alias %q", alias, target)
+		}
+	}
+	return normalised, nil
+}
+
+// validateAliasNameSource validates the alias_name_source, which is a single
+// source or a comma separated priority list of them.
+func validateAliasNameSource(source string) error {
+	for _, source := range splitAliasNameSources(source) {
+		if !strutil.StrListContains(aliasNameSources, source) {
+			return errInvalidAliasNameSource
+		}
+	}
+	return nil
+}
+
+// splitAliasNameSources returns the sources of the comma separated priority
+// list of alias name sources, in order.
+func splitAliasNameSources(source string) []string {
+	sources := strings.Split(source, ",")
+	for i := range sources {
+		sources[i] = strings.TrimSpace(sources[i])
+	}
+	return sources
+}
+
+func validateVerificationPrecedence(precedence string) error {
+	if !strutil.StrListContains(verificationPrecedences, precedence) {
+		return errInvalidVerificationPrecedence
+	}
+	return nil
+}
+
+func validateMetadataOverflow(overflow string) error {
+	if !strutil.StrListContains(metadataOverflows, overflow) {
+		return errInvalidMetadataOverflow
+	}
+	return nil
+}
+
+func validateSANotFoundMetadataMode(mode string) error {
+	if !strutil.StrListContains(saNotFoundMetadataModes, mode) {
+		return errInvalidSANotFoundMetadataMode
+	}
+	return nil
+}
+
+func validateTokenReviewAPIVersion(version string) error {
+	if !strutil.StrListContains(tokenReviewAPIVersions, version) {
+		return errInvalidTokenReviewAPIVersion
+	}
+	return nil
+}
+
+func validateAnnotationCollisionMode(mode string) error {
+	if !strutil.StrListContains(annotationCollisionModes, mode) {
+		return errInvalidAnnotationCollisionMode
+	}
+	return nil
+}
+
+func validateClaimParseOrder(order string) error {
+	if !strutil.StrListContains(claimParseOrders, order) {
+		return errInvalidClaimParseOrder
+	}
+	return nil
+}
+
+func validateTokenReviewUnreachableMode(mode string) error {
+	if !strutil.StrListContains(tokenReviewUnreachableModes, mode) {
+		return errInvalidTokenReviewUnreachableMode
+	}
+	return nil
 }
 
 var backendHelp string = `
@@ -0,0 +1,42 @@
+package kubeauth
+
+import "sync"
+
+// cacheStats counts the lookups served by a cache and the entries it evicted.
+type cacheStats struct {
+	l         sync.Mutex
+	hits      int
+	misses    int
+	evictions int
+}
+
+func (s *cacheStats) hit() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.hits++
+}
+
+func (s *cacheStats) miss() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.misses++
+}
+
+func (s *cacheStats) evict(n int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.evictions += n
+}
+
+// snapshot returns the counts along with the current size of the cache, as
+// reported by cache/stats.
+func (s *cacheStats) snapshot(size int) map[string]interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return map[string]interface{}{
+		"size":      size,
+		"hits":      s.hits,
+		"misses":    s.misses,
+		"evictions": s.evictions,
+	}
+}
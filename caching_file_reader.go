@@ -23,6 +23,9 @@ type cachingFileReader struct {
 	// currentTime is a function that returns the current local time.
 	// Normally set to time.Now but it can be overwritten by test cases to manipulate time.
 	currentTime func() time.Time
+
+	// stats counts the reads served from the in-memory copy.
+	stats cacheStats
 }
 
 type cachedFile struct {
@@ -48,8 +51,10 @@ func (r *cachingFileReader) ReadFile() (string, error) {
 	cache := r.cache
 	r.l.RUnlock()
 	if now.Before(cache.expiry) {
+		r.stats.hit()
 		return cache.buf, nil
 	}
+	r.stats.miss()
 
 	// Slow path: read the file from disk.
 	r.l.Lock()
@@ -59,6 +64,9 @@ func (r *cachingFileReader) ReadFile() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if !r.cache.expiry.IsZero() {
+		r.stats.evict(1)
+	}
 	r.cache = cachedFile{
 		buf:    string(buf),
 		expiry: now.Add(r.ttl),
@@ -66,3 +74,15 @@ func (r *cachingFileReader) ReadFile() (string, error) {
 
 	return r.cache.buf, nil
 }
+
+// Stats returns the read counts of the reader, its size is 1 once the file
+// has been read.
+func (r *cachingFileReader) Stats() map[string]interface{} {
+	r.l.RLock()
+	size := 0
+	if !r.cache.expiry.IsZero() {
+		size = 1
+	}
+	r.l.RUnlock()
+	return r.stats.snapshot(size)
+}
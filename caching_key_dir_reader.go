@@ -0,0 +1,128 @@
+package kubeauth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+// pemKeysDirExtensions are the extensions of the files loaded from pem_keys_dir.
+var pemKeysDirExtensions = []string{".pem", ".pub"}
+
+// cachingKeyDirReader loads the public keys from the PEM files in a directory
+// and keeps an in-memory copy of them, until the copy is considered stale.
+// Next ReadKeys() after expiry will re-scan the directory, picking up added
+// and removed files.
+type cachingKeyDirReader struct {
+	// path is the path to the directory holding the PEM files.
+	path string
+
+	// ttl is the time-to-live duration when cached keys are considered stale
+	ttl time.Duration
+
+	// cache holds the keys loaded from the directory.
+	cache cachedKeys
+
+	l sync.RWMutex
+
+	// currentTime is a function that returns the current local time.
+	// Normally set to time.Now but it can be overwritten by test cases to manipulate time.
+	currentTime func() time.Time
+
+	// logger reports the files which could not be loaded.
+	logger log.Logger
+
+	// stats counts the reads served from the in-memory copy.
+	stats cacheStats
+}
+
+type cachedKeys struct {
+	// keys are the public keys parsed from the files in the directory.
+	keys []interface{}
+
+	// expiry is the time when the cached keys are considered stale and must be re-read.
+	expiry time.Time
+}
+
+func newCachingKeyDirReader(path string, ttl time.Duration, currentTime func() time.Time, logger log.Logger) *cachingKeyDirReader {
+	return &cachingKeyDirReader{
+		path:        path,
+		ttl:         ttl,
+		currentTime: currentTime,
+		logger:      logger,
+	}
+}
+
+// ReadKeys returns the public keys of the directory. Files which can not be
+// read or parsed are logged and skipped.
+func (r *cachingKeyDirReader) ReadKeys() ([]interface{}, error) {
+	// Fast path requiring read lock only: keys are already in memory and not stale.
+	r.l.RLock()
+	now := r.currentTime()
+	cache := r.cache
+	r.l.RUnlock()
+	if now.Before(cache.expiry) {
+		r.stats.hit()
+		return cache.keys, nil
+	}
+	r.stats.miss()
+
+	// Slow path: scan the directory.
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	files, err := ioutil.ReadDir(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []interface{}
+	for _, file := range files {
+		if file.IsDir() || !hasPEMKeysDirExtension(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(r.path, file.Name())
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			r.logger.Warn("failed to read public key file, skipping", "path", path, "error", err)
+			continue
+		}
+		key, err := parsePublicKeyPEM(buf)
+		if err != nil {
+			r.logger.Warn("failed to parse public key file, skipping", "path", path, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	r.stats.evict(len(r.cache.keys))
+	r.cache = cachedKeys{
+		keys:   keys,
+		expiry: now.Add(r.ttl),
+	}
+
+	return r.cache.keys, nil
+}
+
+// Stats returns the read counts of the reader, its size is the number of
+// cached keys.
+func (r *cachingKeyDirReader) Stats() map[string]interface{} {
+	r.l.RLock()
+	size := len(r.cache.keys)
+	r.l.RUnlock()
+	return r.stats.snapshot(size)
+}
+
+func hasPEMKeysDirExtension(name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range pemKeysDirExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
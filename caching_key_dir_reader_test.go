@@ -0,0 +1,75 @@
+package kubeauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+)
+
+func TestCachingKeyDirReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pem_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	currentTime := time.Now()
+
+	r := newCachingKeyDirReader(dir, 1*time.Minute,
+		func() time.Time {
+			return currentTime
+		}, log.NewNullLogger())
+
+	// Write an initial key, a file which isn't a key and a file with an
+	// unrelated extension and check that only the key is loaded.
+	ioutil.WriteFile(filepath.Join(dir, "rsa.pem"), []byte(testRSACert), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("not a key"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "ec.txt"), []byte(testECCert), 0644)
+	got, err := r.ReadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d keys, expected 1", len(got))
+	}
+
+	// Add a key and remove the initial one.
+	ioutil.WriteFile(filepath.Join(dir, "ec.pub"), []byte(testECCert), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "minikube.pub"), []byte(testMinikubePubKey), 0644)
+	os.Remove(filepath.Join(dir, "rsa.pem"))
+
+	// Advance simulated time, but not enough for cache to expire.
+	currentTime = currentTime.Add(30 * time.Second)
+
+	// Read again and check we still got the old cached keys.
+	got, err = r.ReadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d keys, expected 1", len(got))
+	}
+
+	// Advance simulated time for cache to expire.
+	currentTime = currentTime.Add(30 * time.Second)
+
+	// Read again and check that we got the new keys.
+	got, err = r.ReadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d keys, expected 2", len(got))
+	}
+
+	// A missing directory is an error.
+	os.RemoveAll(dir)
+	currentTime = currentTime.Add(time.Minute)
+	if _, err := r.ReadKeys(); err == nil {
+		t.Fatal("expected an error reading a missing directory")
+	}
+}
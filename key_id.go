@@ -0,0 +1,94 @@
+package kubeauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/briankassouf/jose/jws"
+)
+
+// publicKeyIDs returns the key ids a token signed by key may carry in its kid
+// header: the RFC 7638 JWK thumbprint of the key, and the id kubernetes
+// derives from its PKIX encoding.
+func publicKeyIDs(key interface{}) []string {
+	var ids []string
+
+	if thumbprint := jwkThumbprint(key); thumbprint != "" {
+		ids = append(ids, thumbprint)
+	}
+	if der, err := x509.MarshalPKIXPublicKey(key); err == nil {
+		sum := sha256.Sum256(der)
+		ids = append(ids, base64.RawURLEncoding.EncodeToString(sum[:]))
+	}
+
+	return ids
+}
+
+// jwkThumbprint returns the RFC 7638 SHA-256 thumbprint of the JWK
+// representation of key, or "" for unsupported key types.
+func jwkThumbprint(key interface{}) string {
+	var jwk string
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		// The members are in lexicographic order, as required for the
+		// thumbprint.
+		jwk = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			encodeJWKInt(big.NewInt(int64(k.E)), 0), encodeJWKInt(k.N, 0))
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			k.Curve.Params().Name, encodeJWKInt(k.X, size), encodeJWKInt(k.Y, size))
+	default:
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// encodeJWKInt base64url encodes i big-endian, left padded to size bytes.
+func encodeJWKInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// keysForKid returns the keys whose ids match kid. If kid is empty or matches
+// none of them, all keys are returned so that tokens with unknown kids are
+// still verified against every key.
+func keysForKid(keys []interface{}, kid string) []interface{} {
+	if kid == "" {
+		return keys
+	}
+
+	var matching []interface{}
+	for _, key := range keys {
+		for _, id := range publicKeyIDs(key) {
+			if id == kid {
+				matching = append(matching, key)
+				break
+			}
+		}
+	}
+	if len(matching) == 0 {
+		return keys
+	}
+	return matching
+}
+
+// jwtKid returns the kid header of the JWT, or "" if it has none.
+func jwtKid(jwtStr string) string {
+	parsedJWS, err := jws.Parse([]byte(jwtStr))
+	if err != nil {
+		return ""
+	}
+	kid, _ := parsedJWS.Protected().Get("kid").(string)
+	return kid
+}
@@ -0,0 +1,155 @@
+package kubeauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/briankassouf/jose/crypto"
+	"github.com/briankassouf/jose/jws"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example key of RFC 7638, section 3.1.
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	if thumbprint := jwkThumbprint(key); thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint %q", thumbprint)
+	}
+}
+
+func TestKeysForKid(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []interface{}{&testSigningKey.PublicKey, &otherKey.PublicKey}
+
+	testCases := map[string]struct {
+		kid  string
+		want []interface{}
+	}{
+		"no kid": {
+			want: keys,
+		},
+		"jwk thumbprint": {
+			kid:  jwkThumbprint(&otherKey.PublicKey),
+			want: []interface{}{&otherKey.PublicKey},
+		},
+		"kubernetes key id": {
+			kid:  publicKeyIDs(&testSigningKey.PublicKey)[1],
+			want: []interface{}{&testSigningKey.PublicKey},
+		},
+		"unknown kid": {
+			kid:  "unknown",
+			want: keys,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := keysForKid(keys, tc.kid)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %d keys, got %d", len(tc.want), len(got))
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("unexpected key %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginRotatingKeys(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	config := defaultTestBackendConfig()
+	config.pems = []string{testSigningKeyPEM, otherKeyPEM}
+	config.saName = testProjectedName
+	b, storage := setupBackend(t, config)
+	b.(*kubeAuthBackend).reviewFactory = testProjectedMockFactory
+
+	sign := func(key *rsa.PrivateKey, kid string) string {
+		token := jws.NewJWT(jws.Claims(testProjectedClaims()), crypto.SigningMethodRS256)
+		if kid != "" {
+			token.(jws.JWS).Protected().Set("kid", kid)
+		}
+		b, err := token.Serialize(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	testCases := map[string]struct {
+		jwt        string
+		wantReason string
+	}{
+		"current key": {
+			jwt: sign(testSigningKey, publicKeyIDs(&testSigningKey.PublicKey)[1]),
+		},
+		"next key": {
+			jwt: sign(otherKey, jwkThumbprint(&otherKey.PublicKey)),
+		},
+		"unknown kid": {
+			jwt: sign(otherKey, "unknown"),
+		},
+		"no kid": {
+			jwt: sign(testSigningKey, ""),
+		},
+		// The keys identified by the kid are the only ones tried.
+		"kid of another key": {
+			jwt:        sign(otherKey, jwkThumbprint(&testSigningKey.PublicKey)),
+			wantReason: reasonSignatureInvalid,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  tc.jwt,
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if tc.wantReason == "" {
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatalf("err:%s resp:%#v\n", err, resp)
+				}
+				return
+			}
+			if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+				t.Fatalf("expected a 403 coded error, got %#v", err)
+			}
+			if reasonCode(err) != tc.wantReason {
+				t.Fatalf("expected reason %q, got %#v", tc.wantReason, err)
+			}
+		})
+	}
+}
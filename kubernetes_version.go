@@ -0,0 +1,112 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+)
+
+// kubernetesVersion is the major, minor and patch version of a Kubernetes
+// release.
+type kubernetesVersion [3]int
+
+// parseKubernetesVersion parses versions as written by operators, e.g. "1.21",
+// and as reported by the apiserver, e.g. "v1.21.4-eks-3fa2b1". Anything after
+// the patch version is ignored.
+func parseKubernetesVersion(s string) (kubernetesVersion, error) {
+	var v kubernetesVersion
+
+	trimmed := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+
+	parts := strings.Split(trimmed, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid kubernetes version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid kubernetes version %q", s)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// less reports whether v is an older release than o.
+func (v kubernetesVersion) less(o kubernetesVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+// readKubernetesVersion returns the git version reported by the /version
+// endpoint of the configured apiserver.
+func readKubernetesVersion(ctx context.Context, config *kubeConfig) (string, error) {
+	client := cleanhttp.DefaultClient()
+
+	config.configureTransport(client.Transport.(*http.Transport))
+
+	url := fmt.Sprintf("%s/version", strings.TrimSuffix(config.Host, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// The endpoint is usually readable anonymously, but send the reviewer JWT
+	// when we have one in case anonymous access is disabled.
+	if config.TokenReviewerJWT != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(config.TokenReviewerJWT)))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to talk to kubernetes API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from kubernetes API", resp.StatusCode)
+	}
+
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to parse version response: %v", err)
+	}
+	if info.GitVersion == "" {
+		return "", fmt.Errorf("version response does not contain gitVersion")
+	}
+
+	return info.GitVersion, nil
+}
+
+// kubernetesVersionWarning returns a warning if the apiserver version is
+// older than the configured minimum, or if it could not be determined.
+func kubernetesVersionWarning(minimum, serverVersion string) string {
+	want, err := parseKubernetesVersion(minimum)
+	if err != nil {
+		return err.Error()
+	}
+	have, err := parseKubernetesVersion(serverVersion)
+	if err != nil {
+		return fmt.Sprintf("unable to compare kubernetes version: %v", err)
+	}
+	if have.less(want) {
+		return fmt.Sprintf("kubernetes version %s is older than min_kubernetes_version %s", serverVersion, minimum)
+	}
+	return ""
+}
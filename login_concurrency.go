@@ -0,0 +1,143 @@
+package kubeauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// loginSemaphore limits the concurrent logins of the backend. Logins waiting
+// for a slot are queued by key, the namespace of their service account when
+// fair, and the queues are served round-robin so that a flood of logins under
+// one key can't starve the others.
+type loginSemaphore struct {
+	limit int
+	fair  bool
+
+	mu     sync.Mutex
+	inUse  int
+	queues map[string][]*loginWaiter
+	// order holds the keys with waiting logins, in the order they are served.
+	order []string
+}
+
+type loginWaiter struct {
+	ready chan struct{}
+	// granted is set once the slot of a releasing login is handed over.
+	granted bool
+}
+
+func newLoginSemaphore(limit int, fair bool) *loginSemaphore {
+	return &loginSemaphore{
+		limit:  limit,
+		fair:   fair,
+		queues: make(map[string][]*loginWaiter),
+	}
+}
+
+// acquire blocks until a slot is available for a login queued under key. If
+// the context has no deadline it fails immediately rather than waiting. The
+// returned function releases the slot.
+func (s *loginSemaphore) acquire(ctx context.Context, key string) (func(), error) {
+	if !s.fair {
+		key = ""
+	}
+
+	errTooMany := newLoginError(http.StatusTooManyRequests, reasonTooManyConcurrentLogins, errors.New("too many concurrent logins"))
+
+	s.mu.Lock()
+	if s.inUse < s.limit && len(s.order) == 0 {
+		s.inUse++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		s.mu.Unlock()
+		return nil, errTooMany
+	}
+
+	w := &loginWaiter{ready: make(chan struct{})}
+	if len(s.queues[key]) == 0 {
+		s.order = append(s.order, key)
+	}
+	s.queues[key] = append(s.queues[key], w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	if w.granted {
+		// The slot was handed over as the context expired, pass it on.
+		s.mu.Unlock()
+		s.release()
+		return nil, errTooMany
+	}
+	s.remove(key, w)
+	s.mu.Unlock()
+	return nil, errTooMany
+}
+
+// release hands the slot over to the next waiting login, or frees it.
+func (s *loginSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.order) == 0 {
+		s.inUse--
+		return
+	}
+
+	key := s.order[0]
+	w := s.queues[key][0]
+	s.queues[key] = s.queues[key][1:]
+	s.order = s.order[1:]
+	if len(s.queues[key]) > 0 {
+		s.order = append(s.order, key)
+	} else {
+		delete(s.queues, key)
+	}
+
+	w.granted = true
+	close(w.ready)
+}
+
+// remove drops a waiter which gave up from the queue of key. It must be called
+// with mu held.
+func (s *loginSemaphore) remove(key string, w *loginWaiter) {
+	queue := s.queues[key]
+	for i := range queue {
+		if queue[i] == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		s.queues[key] = queue
+		return
+	}
+
+	delete(s.queues, key)
+	for i := range s.order {
+		if s.order[i] == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// globalLoginSemaphore returns the semaphore limiting the concurrent logins
+// across all roles, replacing it when the limit or fairness changed.
+func (b *kubeAuthBackend) globalLoginSemaphore(limit int, fair bool) *loginSemaphore {
+	b.loginSemaphoresLock.Lock()
+	defer b.loginSemaphoresLock.Unlock()
+
+	if b.globalLoginSem == nil || b.globalLoginSem.limit != limit || b.globalLoginSem.fair != fair {
+		b.globalLoginSem = newLoginSemaphore(limit, fair)
+	}
+	return b.globalLoginSem
+}
@@ -0,0 +1,233 @@
+package kubeauth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginSemaphoreFairness(t *testing.T) {
+	testCases := map[string]struct {
+		fair bool
+		want []string
+	}{
+		// The quiet namespace is served right after the first noisy login.
+		"fair": {
+			fair: true,
+			want: []string{"noisy", "quiet", "noisy", "noisy", "noisy", "noisy"},
+		},
+		// The quiet namespace waits behind the whole flood.
+		"arrival order": {
+			want: []string{"noisy", "noisy", "noisy", "noisy", "noisy", "quiet"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			s := newLoginSemaphore(1, tc.fair)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			release, err := s.acquire(ctx, "noisy")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			type grant struct {
+				namespace string
+				release   func()
+			}
+			grants := make(chan grant)
+			enqueue := func(namespace string, waiting int) {
+				go func() {
+					release, err := s.acquire(ctx, namespace)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					grants <- grant{namespace, release}
+				}()
+				// Wait for the login to be queued to keep the arrival order.
+				for {
+					s.mu.Lock()
+					queued := 0
+					for _, queue := range s.queues {
+						queued += len(queue)
+					}
+					s.mu.Unlock()
+					if queued == waiting {
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+			for i := 1; i <= 5; i++ {
+				enqueue("noisy", i)
+			}
+			enqueue("quiet", 6)
+
+			var got []string
+			release()
+			for range tc.want {
+				g := <-grants
+				got = append(got, g.namespace)
+				g.release()
+			}
+			if diff := deep.Equal(tc.want, got); diff != nil {
+				t.Fatal(diff)
+			}
+
+			// All the slots are free again.
+			if s.inUse != 0 || len(s.order) != 0 || len(s.queues) != 0 {
+				t.Fatalf("expected an idle semaphore, got %d in use and %d queued", s.inUse, len(s.order))
+			}
+		})
+	}
+}
+
+func TestLoginSemaphoreDeadline(t *testing.T) {
+	s := newLoginSemaphore(1, true)
+	release, err := s.acquire(context.Background(), "noisy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, "quiet"); err == nil {
+		t.Fatal("expected the login to give up at the deadline")
+	}
+	if len(s.order) != 0 || len(s.queues) != 0 {
+		t.Fatalf("expected the login which gave up to be dequeued, got %#v", s.queues)
+	}
+
+	release()
+	if _, err := s.acquire(context.Background(), "quiet"); err != nil {
+		t.Fatalf("expected the released slot to be free, got %v", err)
+	}
+}
+
+func TestLoginGlobalConcurrency(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	b.(*kubeAuthBackend).reviewFactory = func(config *kubeConfig) tokenReviewer {
+		return &blockingTokenReview{
+			tokenReviewer: testMockTokenReviewFactory(config),
+			started:       started,
+			unblock:       unblock,
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":                 testDefaultPEMs,
+			"kubernetes_host":          "host",
+			"kubernetes_ca_cert":       testCACert,
+			"global_login_concurrency": 1,
+			"fair_concurrency":         true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	login := func() error {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  jwtData,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+
+	errs := make(chan error)
+	go func() { errs <- login() }()
+	<-started
+
+	err = login()
+	if err == nil || err.Error() != `too many concurrent logins for role "plugin-test" (reason_code: TOO_MANY_CONCURRENT_LOGINS)` {
+		t.Fatalf("expected too many concurrent logins error, got %v", err)
+	}
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 coded error, got %#v", err)
+	}
+
+	close(unblock)
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+}
+
+func TestRoleDeleteDropsLoginSemaphore(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+	kb := b.(*kubeAuthBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/team-a-1",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_service_account_names":      "name",
+			"bound_service_account_namespaces": "namespace",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, name := range []string{"plugin-test", "team-a-1"} {
+		release, err := kb.acquireLoginSlot(context.Background(), name, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	requests := map[string]*logical.Request{
+		"plugin-test": {
+			Operation: logical.DeleteOperation,
+			Path:      "role/Plugin-Test",
+			Storage:   storage,
+		},
+		"team-a-1": {
+			Operation: logical.UpdateOperation,
+			Path:      "roles/delete-by-prefix",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"prefix": "team-a-",
+			},
+		},
+	}
+	for name, req := range requests {
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		kb.loginSemaphoresLock.Lock()
+		_, ok := kb.loginSemaphores[name]
+		kb.loginSemaphoresLock.Unlock()
+		if ok {
+			t.Fatalf("expected the login semaphore of %q to be dropped", name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package kubeauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/briankassouf/jose/jwt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Reason codes are stable, machine-readable identifiers attached to denied
+// logins. Clients should rely on these rather than on the human readable
+// message, which may change between releases.
+const (
+	reasonCIDRNotAuthorized           = "CIDR_NOT_AUTHORIZED"
+	reasonNotServiceAccountToken      = "NOT_SERVICE_ACCOUNT_TOKEN"
+	reasonTLSRequired                 = "TLS_REQUIRED"
+	reasonJWTMalformed                = "JWT_MALFORMED"
+	reasonNamespaceNotAuthorized      = "NAMESPACE_NOT_AUTHORIZED"
+	reasonSANameNotAuthorized         = "SA_NAME_NOT_AUTHORIZED"
+	reasonDefaultSANotPermitted       = "DEFAULT_SA_NOT_PERMITTED"
+	reasonNodeClaimMissing            = "NODE_CLAIM_MISSING"
+	reasonNodeNameNotAuthorized       = "NODE_NAME_NOT_AUTHORIZED"
+	reasonRequiredClaimMissing        = "REQUIRED_CLAIM_MISSING"
+	reasonIssuerInvalid               = "ISSUER_INVALID"
+	reasonAudienceInvalid             = "AUDIENCE_INVALID"
+	reasonTokenExpired                = "TOKEN_EXPIRED"
+	reasonTokenNotYetValid            = "TOKEN_NOT_YET_VALID"
+	reasonTokenIssuedInFuture         = "TOKEN_ISSUED_IN_FUTURE"
+	reasonSignatureInvalid            = "SIGNATURE_INVALID"
+	reasonTooManyConcurrentLogins     = "TOO_MANY_CONCURRENT_LOGINS"
+	reasonTokenReviewFailed           = "TOKEN_REVIEW_FAILED"
+	reasonTokenReviewAudienceMismatch = "TOKEN_REVIEW_AUDIENCE_MISMATCH"
+	reasonTokenReviewTokenExpired     = "TOKEN_REVIEW_TOKEN_EXPIRED"
+	reasonTokenReviewNotFound         = "TOKEN_REVIEW_NOT_FOUND"
+	reasonTokenReviewerUnauthorized   = "TOKEN_REVIEWER_UNAUTHORIZED"
+	reasonCANotYetValid               = "CA_NOT_YET_VALID"
+	reasonCAExpired                   = "CA_EXPIRED"
+	reasonMetadataTooLarge            = "METADATA_TOO_LARGE"
+	reasonAliasClaimMissing           = "ALIAS_CLAIM_MISSING"
+	reasonWildcardBinding             = "WILDCARD_BINDING_NOT_PERMITTED"
+	reasonPodClaimMissing             = "POD_CLAIM_MISSING"
+	reasonOwnerNotAuthorized          = "OWNER_NOT_AUTHORIZED"
+	reasonTokenExpiryMissing          = "TOKEN_EXPIRY_MISSING"
+	reasonEmbeddedCertMismatch        = "EMBEDDED_CERT_MISMATCH"
+	reasonSANamespaceMismatch         = "SA_NAMESPACE_MISMATCH"
+	reasonClaimNotAuthorized          = "CLAIM_NOT_AUTHORIZED"
+	reasonKidMissing                  = "KID_MISSING"
+	reasonAudienceDenied              = "AUDIENCE_DENIED"
+	reasonProjectedClaimsMalformed    = "PROJECTED_CLAIMS_MALFORMED"
+	reasonTokenAddressMismatch        = "TOKEN_ADDRESS_MISMATCH"
+	reasonUnsignedToken               = "UNSIGNED_TOKEN"
+	reasonTooManyAudiences            = "TOO_MANY_AUDIENCES"
+	reasonRoleClaimNotAllowed         = "ROLE_CLAIM_NOT_ALLOWED"
+	reasonNamespaceNotFound           = "NAMESPACE_NOT_FOUND"
+	reasonTokenReviewUnreachable      = "TOKEN_REVIEW_UNREACHABLE"
+	reasonPodNotFound                 = "POD_NOT_FOUND"
+)
+
+// legacyStatusCodes maps the statuses of login errors introduced alongside
+// reason codes to the ones older clients expect, see legacy_error_codes.
+var legacyStatusCodes = map[int]int{
+	http.StatusTooManyRequests:       http.StatusForbidden,
+	http.StatusRequestEntityTooLarge: http.StatusBadRequest,
+	http.StatusServiceUnavailable:    http.StatusForbidden,
+}
+
+// loginError is returned when a login is denied. Alongside the human readable
+// message it carries the HTTP status to respond with and a stable reason code.
+// Vault responds to a coded error with its status and message only, so the
+// reason code is part of the message.
+type loginError struct {
+	status int
+	reason string
+	err    error
+	// role is the name of the role the login was denied for, if known.
+	role string
+}
+
+var _ logical.HTTPCodedError = (*loginError)(nil)
+
+func newLoginError(status int, reason string, err error) *loginError {
+	return &loginError{
+		status: status,
+		reason: reason,
+		err:    err,
+	}
+}
+
+func (e *loginError) Error() string {
+	msg := strings.TrimRight(e.err.Error(), "\n")
+	if e.role != "" {
+		msg = fmt.Sprintf("%s for role %q", msg, e.role)
+	}
+	return fmt.Sprintf("%s (reason_code: %s)", msg, e.reason)
+}
+
+// Code implements logical.HTTPCodedError.
+func (e *loginError) Code() int {
+	return e.status
+}
+
+// WrappedErrors implements errwrap.Wrapper.
+func (e *loginError) WrappedErrors() []error {
+	return []error{e.err}
+}
+
+// jwtValidationError maps the errors returned by the JWT validator onto login
+// errors. Errors which are already login errors, or which are not related to
+// the validity of the token, are returned untouched.
+func jwtValidationError(err error) error {
+	switch err {
+	case jwt.ErrInvalidISSClaim:
+		return newLoginError(http.StatusForbidden, reasonIssuerInvalid, err)
+	case jwt.ErrInvalidAUDClaim:
+		return newLoginError(http.StatusForbidden, reasonAudienceInvalid, err)
+	case jwt.ErrTokenIsExpired:
+		return newLoginError(http.StatusForbidden, reasonTokenExpired, err)
+	case jwt.ErrTokenNotYetValid:
+		return newLoginError(http.StatusForbidden, reasonTokenNotYetValid, err)
+	default:
+		return err
+	}
+}
+
+// roleLoginDenied converts err into the values returned from a login callback,
+// naming roleName in the message of a *loginError so that denials can be told
+// apart across roles. No response is returned: Vault responds to an error
+// response with a 400 and its message, dropping the status and reason code of
+// the *loginError.
+func roleLoginDenied(roleName string, err error) (*logical.Response, error) {
+	if lerr, ok := err.(*loginError); ok && lerr.role == "" {
+		roleErr := *lerr
+		roleErr.role = roleName
+		err = &roleErr
+	}
+	return nil, err
+}
+
+// withLegacyErrorCodes wraps a login callback so that, when legacy_error_codes
+// is set, the statuses of its login errors are downgraded according to
+// legacyStatusCodes. The reason code in the error message is kept.
+func (b *kubeAuthBackend) withLegacyErrorCodes(op framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		resp, err := op(ctx, req, data)
+
+		lerr, ok := err.(*loginError)
+		if !ok {
+			return resp, err
+		}
+		legacy, ok := legacyStatusCodes[lerr.status]
+		if !ok {
+			return resp, err
+		}
+
+		b.l.RLock()
+		config, cerr := b.config(ctx, req.Storage)
+		b.l.RUnlock()
+		if cerr != nil || config == nil || !config.LegacyErrorCodes {
+			return resp, err
+		}
+
+		legacyErr := *lerr
+		legacyErr.status = legacy
+		return resp, &legacyErr
+	}
+}
@@ -0,0 +1,83 @@
+package kubeauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// reasonCode returns the reason code of a *loginError, or the empty string
+// for any other error.
+func reasonCode(err error) string {
+	lerr, ok := err.(*loginError)
+	if !ok {
+		return ""
+	}
+	return lerr.reason
+}
+
+// respondError returns the status and error Vault's HTTP layer responds with
+// for the values returned from a request.
+func respondError(req *logical.Request, resp *logical.Response, err error) (int, error) {
+	status, err := logical.RespondErrorCommon(req, resp, err)
+	if err != nil {
+		logical.AdjustErrorStatusCode(&status, err)
+	}
+	return status, err
+}
+
+func TestLoginErrorHTTPResponse(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_service_account_names":      "app",
+			"bound_service_account_namespaces": testNamespace,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "plugin-test",
+			"jwt":  jwtData,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "127.0.0.1",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+
+	status, err := respondError(req, resp, err)
+	if status != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, status)
+	}
+	if err == nil || !strings.Contains(err.Error(), "reason_code: "+reasonSANameNotAuthorized) {
+		t.Fatalf("expected the reason code in the error, got %v", err)
+	}
+}
+
+func TestLoginErrorMessage(t *testing.T) {
+	lerr := newLoginError(http.StatusTooManyRequests, reasonTooManyConcurrentLogins, errors.New("too many concurrent logins\n"))
+	if got, want := lerr.Error(), "too many concurrent logins (reason_code: TOO_MANY_CONCURRENT_LOGINS)"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	_, err := roleLoginDenied("plugin-test", lerr)
+	if got, want := err.Error(), `too many concurrent logins for role "plugin-test" (reason_code: TOO_MANY_CONCURRENT_LOGINS)`; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
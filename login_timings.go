@@ -0,0 +1,62 @@
+package kubeauth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The stages of a login reported in its timings.
+const (
+	timingSignatureVerification = "signature_verification"
+	timingClaimValidation       = "claim_validation"
+	timingTokenReview           = "token_review"
+	timingAnnotationRead        = "annotation_read"
+)
+
+// loginTimingsKey is the context key of the loginTimings collected for a login.
+type loginTimingsKey struct{}
+
+// loginTimings collects the time spent in each stage of a login.
+type loginTimings struct {
+	l      sync.Mutex
+	stages map[string]time.Duration
+}
+
+// withLoginTimings returns a context collecting the time spent in the stages
+// of the login timed with it.
+func withLoginTimings(ctx context.Context) (context.Context, *loginTimings) {
+	timings := &loginTimings{stages: make(map[string]time.Duration)}
+	return context.WithValue(ctx, loginTimingsKey{}, timings), timings
+}
+
+// timeStage starts timing stage and returns the function ending it, which
+// adds the elapsed time to the loginTimings of ctx. Without loginTimings the
+// returned function does nothing.
+func timeStage(ctx context.Context, stage string) func() {
+	timings, ok := ctx.Value(loginTimingsKey{}).(*loginTimings)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+
+		timings.l.Lock()
+		defer timings.l.Unlock()
+		timings.stages[stage] += elapsed
+	}
+}
+
+// Milliseconds returns the time spent in each stage in milliseconds. Stages
+// the login skipped are omitted.
+func (t *loginTimings) Milliseconds() map[string]float64 {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	ms := make(map[string]float64, len(t.stages))
+	for stage, d := range t.stages {
+		ms[stage] = float64(d) / float64(time.Millisecond)
+	}
+	return ms
+}
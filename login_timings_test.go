@@ -0,0 +1,64 @@
+package kubeauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginTimings(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+
+	for name, enabled := range map[string]bool{"enabled": true, "disabled": false} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"pem_keys":           testDefaultPEMs,
+					"kubernetes_host":    "host",
+					"kubernetes_ca_cert": testCACert,
+					"enable_custom_metadata_from_annotations": true,
+					"include_timing": enabled,
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  jwtData,
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			timings, ok := resp.Data["timings"].(map[string]float64)
+			if !enabled {
+				if ok {
+					t.Fatalf("expected no timings, got %#v", timings)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("expected timings, got %#v", resp.Data)
+			}
+			for _, stage := range []string{timingSignatureVerification, timingClaimValidation, timingTokenReview, timingAnnotationRead} {
+				if ms, ok := timings[stage]; !ok || ms < 0 {
+					t.Fatalf("expected a timing for %s, got %#v", stage, timings)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package kubeauth
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// metadataTemplateFuncs is the set of functions available to metadata
+// templates in addition to the text/template builtins. None of them perform
+// any I/O.
+var metadataTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+	"join":       strings.Join,
+}
+
+// metadataTemplateData is the data metadata templates are evaluated against.
+type metadataTemplateData struct {
+	Namespace          string
+	ServiceAccountName string
+	ServiceAccountUID  string
+
+	// Claims holds all the claims of the validated JWT.
+	Claims map[string]interface{}
+}
+
+// parseMetadataTemplate parses the template producing the metadata value for
+// key, rejecting keys reserved for the metadata populated by the backend.
+func parseMetadataTemplate(key, text string) (*template.Template, error) {
+	if isReservedMetadataKey(key) {
+		return nil, fmt.Errorf("metadata key %q is reserved", key)
+	}
+
+	tmpl, err := template.New(key).Option("missingkey=error").Funcs(metadataTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata template for %q: %v", key, err)
+	}
+	return tmpl, nil
+}
+
+// renderMetadataTemplates evaluates the templates against the service
+// account's validated claims and returns the resulting metadata.
+func renderMetadataTemplates(templates map[string]string, sa *serviceAccount) (map[string]string, error) {
+	uid, err := sa.uid()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &metadataTemplateData{
+		Namespace:          sa.namespace(),
+		ServiceAccountName: sa.name(),
+		ServiceAccountUID:  uid,
+		Claims:             sa.claims,
+	}
+
+	metadata := make(map[string]string, len(templates))
+	for key, text := range templates {
+		tmpl, err := parseMetadataTemplate(key, text)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to evaluate metadata template for %q: %v", key, err)
+		}
+		metadata[key] = buf.String()
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,97 @@
+package kubeauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// namespaceCacheTTL is how long a namespace is known to exist for. Deleted
+// namespaces keep admitting logins for at most this long.
+const namespaceCacheTTL = 30 * time.Second
+
+// namespaceCache caches the namespaces found to exist. Missing namespaces
+// are not cached, so that logins from a namespace are admitted as soon as it
+// is created.
+type namespaceCache struct {
+	l       sync.Mutex
+	entries map[string]time.Time
+
+	// currentTime is a function that returns the current local time.
+	// Normally set to time.Now but it can be overwritten by test cases to manipulate time.
+	currentTime func() time.Time
+
+	// stats counts the namespaces found in the cache.
+	stats cacheStats
+}
+
+func newNamespaceCache(currentTime func() time.Time) *namespaceCache {
+	return &namespaceCache{
+		entries:     make(map[string]time.Time),
+		currentTime: currentTime,
+	}
+}
+
+func (c *namespaceCache) exists(name string) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	expiry, ok := c.entries[name]
+	if !ok || !c.currentTime().Before(expiry) {
+		c.stats.miss()
+		return false
+	}
+	c.stats.hit()
+	return true
+}
+
+func (c *namespaceCache) set(name string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	// Drop the expired entries so that deleted namespaces don't stay cached.
+	now := c.currentTime()
+	for key, expiry := range c.entries {
+		if !now.Before(expiry) {
+			delete(c.entries, key)
+			c.stats.evict(1)
+		}
+	}
+
+	c.entries[name] = now.Add(namespaceCacheTTL)
+}
+
+// Stats returns the lookup counts of the cache, its size is the number of
+// cached namespaces, including expired ones not evicted yet.
+func (c *namespaceCache) Stats() map[string]interface{} {
+	c.l.Lock()
+	size := len(c.entries)
+	c.l.Unlock()
+	return c.stats.snapshot(size)
+}
+
+// checkNamespaceExists denies the login unless the namespace of the token
+// exists in the cluster, catching tokens of deleted namespaces whose
+// signature still verifies.
+func (b *kubeAuthBackend) checkNamespaceExists(ctx context.Context, config *kubeConfig, sa *serviceAccount) error {
+	namespace := sa.namespace()
+	if b.namespaces.exists(namespace) {
+		return nil
+	}
+
+	_, err := b.namespaceReaderFactory(config).NamespaceLabels(ctx, namespace)
+	if kubeerrors.IsNotFound(err) {
+		return newLoginError(http.StatusForbidden, reasonNamespaceNotFound, errors.New("namespace does not exist"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read namespace: %v", err)
+	}
+
+	b.namespaces.set(namespace)
+	return nil
+}
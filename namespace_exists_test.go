@@ -0,0 +1,132 @@
+package kubeauth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNamespaceCache(t *testing.T) {
+	now := time.Now()
+	cache := newNamespaceCache(func() time.Time { return now })
+
+	if cache.exists("default") {
+		t.Fatal("expected an empty cache")
+	}
+	cache.set("default")
+	if !cache.exists("default") {
+		t.Fatal("expected the namespace to be cached")
+	}
+
+	now = now.Add(namespaceCacheTTL)
+	if cache.exists("default") {
+		t.Fatal("expected the namespace to expire")
+	}
+	cache.set("other")
+	want := map[string]interface{}{"size": 1, "hits": 1, "misses": 2, "evictions": 1}
+	for k, v := range want {
+		if cache.Stats()[k] != v {
+			t.Fatalf("expected %s %v, got %#v", k, v, cache.Stats())
+		}
+	}
+}
+
+func TestLoginValidateNamespaceExists(t *testing.T) {
+	testCases := map[string]struct {
+		exists     bool
+		wantErr    string
+		wantReason string
+	}{
+		"present": {
+			exists: true,
+		},
+		"absent": {
+			wantErr:    `namespace does not exist for role "plugin-test" (reason_code: NAMESPACE_NOT_FOUND)`,
+			wantReason: reasonNamespaceNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := defaultTestBackendConfig()
+			b, storage := setupBackend(t, config)
+
+			reads := 0
+			b.(*kubeAuthBackend).namespaceReaderFactory = func(*kubeConfig) namespaceReader {
+				return namespaceReaderFunc(func(ctx context.Context, name string) (map[string]string, error) {
+					reads++
+					if name != testNamespace {
+						t.Errorf("expected namespace %q to be read, got %q", testNamespace, name)
+					}
+					if !tc.exists {
+						return nil, kubeerrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, name)
+					}
+					return map[string]string{}, nil
+				})
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"pem_keys":                  testDefaultPEMs,
+					"kubernetes_host":           "host",
+					"kubernetes_ca_cert":        testCACert,
+					"validate_namespace_exists": true,
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			login := func() (*logical.Response, error) {
+				return b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "login",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"role": "plugin-test",
+						"jwt":  jwtData,
+					},
+					Connection: &logical.Connection{
+						RemoteAddr: "127.0.0.1",
+					},
+				})
+			}
+
+			for i := 0; i < 2; i++ {
+				resp, err = login()
+				if tc.wantErr == "" {
+					if err != nil || (resp != nil && resp.IsError()) {
+						t.Fatalf("err:%s resp:%#v\n", err, resp)
+					}
+					continue
+				}
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+				if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+					t.Fatalf("expected a 403 coded error, got %#v", err)
+				}
+				if reasonCode(err) != tc.wantReason {
+					t.Fatalf("expected reason code %q, got %#v", tc.wantReason, err)
+				}
+			}
+
+			// Only the namespaces found are cached.
+			wantReads := 2
+			if tc.exists {
+				wantReads = 1
+			}
+			if reads != wantReads {
+				t.Fatalf("expected %d namespace reads, got %d", wantReads, reads)
+			}
+		})
+	}
+}
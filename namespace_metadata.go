@@ -0,0 +1,58 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// namespaceLabelMetadataPrefix prefixes the metadata keys of the namespace
+// labels copied for roles setting enable_namespace_metadata.
+const namespaceLabelMetadataPrefix = "ns_label_"
+
+// namespaceReader reads the labels of a namespace.
+type namespaceReader interface {
+	NamespaceLabels(ctx context.Context, name string) (map[string]string, error)
+}
+
+type namespaceReaderFactory func(*kubeConfig) namespaceReader
+
+func namespaceReaderAPIFactory(config *kubeConfig) namespaceReader {
+	return newNamespaceAPI(config)
+}
+
+func (n *namespaceAPI) NamespaceLabels(ctx context.Context, name string) (map[string]string, error) {
+	body, err := n.get(ctx, "/api/v1/namespaces/"+url.PathEscape(name))
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := json.Unmarshal(body, namespace); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into corev1.Namespace: %v", err)
+	}
+	return namespace.Labels, nil
+}
+
+// namespaceLabelMetadata returns the labels allowed by the role, keyed by
+// their metadata key.
+func namespaceLabelMetadata(allowed []string, labels map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for _, key := range allowed {
+		if value, ok := labels[key]; ok {
+			metadata[namespaceLabelMetadataPrefix+key] = value
+		}
+	}
+	return metadata
+}
+
+// isReservedMetadataKey reports whether key is populated by the backend
+// itself, and so can't be set by annotations or templates.
+func isReservedMetadataKey(key string) bool {
+	return strutil.StrListContains(reservedMetadataKeys, key) || strings.HasPrefix(key, namespaceLabelMetadataPrefix)
+}
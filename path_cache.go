@@ -0,0 +1,56 @@
+package kubeauth
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathCacheStats returns the path reporting the statistics of the backend's
+// in-memory caches.
+func pathCacheStats(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/stats$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathCacheStatsRead,
+		},
+		HelpSynopsis:    cacheStatsHelpSyn,
+		HelpDescription: cacheStatsHelpDesc,
+	}
+}
+
+// pathCacheStatsRead reports the size, hits, misses and evictions of each
+// cache.
+func (b *kubeAuthBackend) pathCacheStatsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	stats := map[string]interface{}{
+		"pod_owners":     b.podOwners.Stats(),
+		"token_addrs":    b.tokenAddrs.Stats(),
+		"namespaces":     b.namespaces.Stats(),
+		"local_sa_token": b.localSATokenReader.Stats(),
+		"local_ca_cert":  b.localCACertReader.Stats(),
+	}
+
+	// The pem_keys_dir reader is only created once the directory is read.
+	b.pemKeysDirLock.Lock()
+	pemKeysDirReader := b.pemKeysDirReader
+	b.pemKeysDirLock.Unlock()
+	if pemKeysDirReader != nil {
+		stats["pem_keys_dir"] = pemKeysDirReader.Stats()
+	} else {
+		stats["pem_keys_dir"] = (&cacheStats{}).snapshot(0)
+	}
+
+	return &logical.Response{
+		Data: stats,
+	}, nil
+}
+
+const cacheStatsHelpSyn = `Reports the statistics of the in-memory caches.`
+const cacheStatsHelpDesc = `
+Returns the size, hits, misses and evictions of the caches kept by the backend:
+the pod owners resolved for bound_owner_references, the tokens bound to remote
+addresses for bind_token_to_remote_addr, the local service account token and
+CA certificate, and the keys loaded from pem_keys_dir. The counts are
+kept per Vault node and reset when the plugin is reloaded.
+`
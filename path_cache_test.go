@@ -0,0 +1,74 @@
+package kubeauth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCacheStats(t *testing.T) {
+	b, storage := getBackend(t)
+
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	now := time.Now()
+	currentTime := func() time.Time { return now }
+	b.(*kubeAuthBackend).localSATokenReader = newCachingFileReader(f.Name(), time.Minute, currentTime)
+	b.(*kubeAuthBackend).podOwners = newPodOwnerCache(currentTime)
+
+	// A miss reading the file, a hit, then a miss replacing the expired copy.
+	r := b.(*kubeAuthBackend).localSATokenReader
+	for _, advance := range []time.Duration{0, 0, time.Minute} {
+		now = now.Add(advance)
+		if _, err := r.ReadFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two misses caching the owners of two pods and a hit for one of them,
+	// then the expired owners are evicted when caching a third pod.
+	c := b.(*kubeAuthBackend).podOwners
+	for _, uid := range []string{"pod-1", "pod-2"} {
+		if _, ok := c.get(uid); ok {
+			t.Fatalf("unexpected owner for %s", uid)
+		}
+		c.set(uid, "Deployment/payments-api")
+	}
+	if _, ok := c.get("pod-1"); !ok {
+		t.Fatal("expected a cached owner for pod-1")
+	}
+	now = now.Add(podOwnerCacheTTL)
+	c.set("pod-3", "Deployment/payments-api")
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cache/stats",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"pod_owners":     map[string]interface{}{"size": 1, "hits": 1, "misses": 2, "evictions": 2},
+		"token_addrs":    map[string]interface{}{"size": 0, "hits": 0, "misses": 0, "evictions": 0},
+		"namespaces":     map[string]interface{}{"size": 0, "hits": 0, "misses": 0, "evictions": 0},
+		"local_sa_token": map[string]interface{}{"size": 1, "hits": 1, "misses": 2, "evictions": 1},
+		"local_ca_cert":  map[string]interface{}{"size": 0, "hits": 0, "misses": 0, "evictions": 0},
+		"pem_keys_dir":   map[string]interface{}{"size": 0, "hits": 0, "misses": 0, "evictions": 0},
+	}
+	if diff := deep.Equal(resp.Data, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -4,11 +4,18 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
 
 	"github.com/briankassouf/jose/jws"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -16,6 +23,16 @@ import (
 const (
 	localCACertPath = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 	localJWTPath    = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// redactedPlaceholder replaces secret values in exported config.
+	redactedPlaceholder = "<redacted>"
+
+	// defaultMaxTokenAudiences is the default max_token_audiences, well above
+	// the few audiences of legitimate tokens.
+	defaultMaxTokenAudiences = 64
+
+	// defaultMinRSAKeyBits is the default min_rsa_key_bits.
+	defaultMinRSAKeyBits = 2048
 )
 
 // pathConfig returns the path configuration for CRUD operations on the backend
@@ -90,6 +107,485 @@ then this plugin will use kubernetes.io/serviceaccount as the default issuer.
 					Name: "Enable reading and parsing service account annotations",
 				},
 			},
+			"require_tls_connection": {
+				Type:        framework.TypeBool,
+				Description: "Reject logins which were not received over a TLS connection",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Require TLS connection for login",
+				},
+			},
+			"allow_default_service_account": {
+				Type:        framework.TypeBool,
+				Description: `Allow tokens for the "default" service account to log in. Defaults to true.`,
+				Default:     true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Allow default service account",
+				},
+			},
+			"not_before_leeway": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration in seconds of clock skew tolerated when validating the nbf claim of a JWT. Expiry is not affected.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Not before leeway",
+				},
+			},
+			"max_roles": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of roles which can be created on this mount. Defaults to 0, which means unlimited.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum roles",
+				},
+			},
+			"kubernetes_tls_server_name": {
+				Type:        framework.TypeString,
+				Description: "Optional server name used for SNI and to verify the hostname of the Kubernetes API server's certificate, regardless of the address being dialed.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Kubernetes TLS server name",
+				},
+			},
+			"pem_keys_dir": {
+				Type:        framework.TypeString,
+				Description: "Optional directory of PEM encoded public keys, in files ending with .pem or .pub, used to verify the signature of JWTs in addition to pem_keys. The directory is re-scanned periodically to pick up added and removed keys.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "PEM keys directory",
+				},
+			},
+			"require_service_account_subject": {
+				Type:        framework.TypeBool,
+				Description: `Reject JWTs whose sub claim is not a service account subject, i.e. does not start with "system:serviceaccount:". Defaults to true.`,
+				Default:     true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Require service account subject",
+				},
+			},
+			"max_future_iat": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional duration in seconds by which the iat claim of a JWT may be ahead of the current time. JWTs issued further in the future are rejected. Defaults to 0, which disables the check.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum future iat",
+				},
+			},
+			"strict_role_fields": {
+				Type:        framework.TypeBool,
+				Description: "Reject role writes containing unknown fields instead of ignoring them. Defaults to false.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Strict role fields",
+				},
+			},
+			"min_kubernetes_version": {
+				Type:        framework.TypeString,
+				Description: `Optional minimum Kubernetes version, e.g. "1.21". When set, reading the config reports the version of the Kubernetes API server and warns if it is older. This is advisory only and not enforced at login.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Minimum Kubernetes version",
+				},
+			},
+			"lowercase_namespace_name_matching": {
+				Type:        framework.TypeBool,
+				Description: "Normalise the service account namespace and name from the JWT to lowercase before matching them against the role and reading the service account from the Kubernetes API. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Lowercase namespace and name matching",
+				},
+			},
+			"required_claims": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Optional list of claims, as dot separated paths, which must be present in the JWT for a login to be accepted, e.g. "kubernetes.io.pod.uid".`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Required claims",
+				},
+			},
+			"verbose_denials": {
+				Type:        framework.TypeBool,
+				Description: "Include the role's bound service account names or namespaces and the values from the JWT in the error of denied logins. Defaults to false, as this discloses the role's bindings to the caller.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Verbose denials",
+				},
+			},
+			"token_review_for_projected_only": {
+				Type:        framework.TypeBool,
+				Description: "Skip the TokenReview API call for legacy service account tokens whose signature was verified with the configured public keys, projected tokens are always reviewed. Legacy tokens are still reviewed when no public keys are configured. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "TokenReview for projected tokens only",
+				},
+			},
+			"common_policies": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional list of policies added to the tokens issued for every role, in addition to the role's own token_policies.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Common policies",
+				},
+			},
+			"verification_precedence": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`Decides the outcome of a login when the signature
+verification with the configured public keys and the TokenReview disagree. Allowed values:
+"%s" accepts a token failing signature verification if the TokenReview accepts it,
+"%s" accepts a token with a valid signature if the TokenReview rejects it,
+"%s" denies the login unless both pass. Defaults to "%s".`,
+					verificationPrecedenceReviewWins, verificationPrecedenceSignatureWins,
+					verificationPrecedenceBothRequired, verificationPrecedenceDefault),
+				Default: verificationPrecedenceDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Verification precedence",
+				},
+			},
+			"namespace_prefix_strip": {
+				Type:        framework.TypeString,
+				Description: `Optional regular expression splitting the service account namespace of multi-tenant clusters, it must define the named groups "tenant" and "namespace", e.g. "^tenant-(?P<tenant>[a-z0-9]+)-(?P<namespace>.+)$". When it matches, the role's bound_service_account_namespaces are matched against the namespace group and the tenant is added to the metadata. Namespaces it does not match are used unchanged.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Namespace prefix strip",
+				},
+			},
+			"sa_read_token": {
+				Type:        framework.TypeString,
+				Description: "Optional bearer token used to read service accounts from the Kubernetes API when enable_custom_metadata_from_annotations is set, for environments where token_reviewer_jwt is not allowed to read them. If not set token_reviewer_jwt is used.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Service account read token",
+				},
+			},
+			"max_metadata_bytes": {
+				Type:        framework.TypeInt,
+				Description: "Optional maximum total size in bytes of the keys and values of the metadata taken from service account annotations. Defaults to 0, no limit.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum metadata bytes",
+				},
+			},
+			"max_metadata_overflow": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`What to do when the metadata taken from service
+account annotations exceeds max_metadata_bytes. Allowed values:
+"%s" drops the annotations past the limit, in key order, and warns,
+"%s" denies the login. Defaults to "%s".`,
+					metadataOverflowTruncate, metadataOverflowFail, metadataOverflowDefault),
+				Default: metadataOverflowDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum metadata overflow",
+				},
+			},
+			"role_aliases": {
+				Type:        framework.TypeKVPairs,
+				Description: "Alternative names for roles, as alias=role pairs. Logins with an alias use the target role and are warned that the alias is deprecated. Intended to keep old names working while renaming roles.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Role Aliases",
+				},
+			},
+			"propagate_audit_id": {
+				Type:        framework.TypeBool,
+				Description: "Add the Audit-Id of the Kubernetes API responses received during a login, comma separated, to the token metadata as k8s_audit_id so that logins can be joined to the API server audit log. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Propagate audit id",
+				},
+			},
+			"legacy_error_codes": {
+				Type:        framework.TypeBool,
+				Description: "Respond to denied logins with the status codes used before reason codes were introduced, mapping 429 and 503 to 403 and 413 to 400, for compatibility with older clients. The reason_code in the error message is unaffected. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Legacy error codes",
+				},
+			},
+			"warn_on_legacy_token": {
+				Type:        framework.TypeBool,
+				Description: "Add a warning to the response of successful logins with a legacy, non-projected, service account token and count them in the legacy_token_login metric. Logins are not denied. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Warn on legacy token",
+				},
+			},
+			"require_exp_claim": {
+				Type:        framework.TypeBool,
+				Description: "Reject JWTs without an exp claim, such as legacy service account tokens which never expire. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Require exp claim",
+				},
+			},
+			"verify_x5c": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag to check the x5c, x5t and x5t#S256 headers of JWTs, when present, against the configured keys. The x5c leaf certificate is matched against the keys of pem_keys and pem_keys_dir, the thumbprints only against the certificates of pem_keys. A JWT whose embedded certificate or thumbprint matches none of them is denied. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Verify x5c",
+				},
+			},
+			"strict_sa_read_namespace": {
+				Type:        framework.TypeBool,
+				Description: "Deny logins whose service account annotations were read from a namespace other than the validated namespace claim of the JWT. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Strict service account read namespace",
+				},
+			},
+			"role_reconcile_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional interval at which the bound namespaces of the roles are checked against the namespaces of the cluster. Roles matching no live namespace are reported at roles/stale, nothing is deleted. Defaults to 0, disabling the reconciliation.",
+				Default:     0,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Role reconcile interval",
+				},
+			},
+			"require_kid": {
+				Type:        framework.TypeBool,
+				Description: "Reject JWTs without a kid header, for clusters whose service account issuer always sets one. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Require kid",
+				},
+			},
+			"sa_not_found_metadata_mode": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`What to do when the service account is not found while
+reading its annotations, e.g. as it was deleted while the token remains valid.
+Only applies when enable_custom_metadata_from_annotations is set. Allowed
+values: "%s" logs a warning and logs in without annotations, "%s" fails the
+login. Defaults to "%s".`,
+					saNotFoundMetadataIgnore, saNotFoundMetadataFail, saNotFoundMetadataDefault),
+				Default: saNotFoundMetadataDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Service account not found metadata mode",
+				},
+			},
+			"jwt_field_name": {
+				Type:        framework.TypeString,
+				Description: `Name of the login request field the JWT is read from, for proxies renaming the jwt field. Defaults to "jwt".`,
+				Default:     "jwt",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "JWT field name",
+				},
+			},
+			"cluster_name": {
+				Type:        framework.TypeString,
+				Description: "Optional human-friendly name of the cluster, added as the cluster_name metadata of every token and entity alias. It can not be overridden by annotations.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Cluster name",
+				},
+			},
+			"api_idle_conn_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Optional time an idle connection to the kubernetes API is kept open before it is closed. Lower it below the idle timeout of intermediaries that silently drop connections. Defaults to 0, using the client default of 90 seconds.",
+				Default:     0,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "API idle connection timeout",
+				},
+			},
+			"api_disable_keepalives": {
+				Type:        framework.TypeBool,
+				Description: "Open a new connection for every request to the kubernetes API rather than reusing idle ones. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "API disable keep-alives",
+				},
+			},
+			"echoable_claims": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Optional list of claims, as dot separated paths, which a login may ask to have returned in the response data with return_claims, e.g. "kubernetes.io.pod.name". No claims are returned unless listed here.`,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Echoable claims",
+				},
+			},
+			"strict_projected_claims": {
+				Type:        framework.TypeBool,
+				Description: "Reject projected tokens whose kubernetes.io claim lacks the namespace, serviceaccount.name or serviceaccount.uid, naming the missing claim in the error. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Strict projected claims",
+				},
+			},
+			"warn_on_alias_collision": {
+				Type:        framework.TypeBool,
+				Description: "Warn when a role is written with the same bindings and alias_name_source as another role but different policies, as logins with either role resolve to the same alias and entity. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Warn on alias collision",
+				},
+			},
+			"global_login_concurrency": {
+				Type:        framework.TypeInt,
+				Description: "Optional maximum number of logins performing checks against the Kubernetes API at the same time across all roles. Logins beyond the limit wait until the request deadline, or fail immediately with a 429 if there is none. Defaults to 0, which means unlimited.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Global login concurrency",
+				},
+			},
+			"fair_concurrency": {
+				Type:        framework.TypeBool,
+				Description: "If true, the logins waiting for global_login_concurrency are admitted round-robin across the namespaces of their service accounts, so that a flood of logins from one namespace can't starve the others. Otherwise they are admitted in arrival order.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Fair concurrency",
+				},
+			},
+			"reject_empty_policy_roles": {
+				Type:        framework.TypeBool,
+				Description: "If true, writing a role without token_policies fails unless common_policies is set, as its tokens would only be granted the default policy.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Reject empty policy roles",
+				},
+			},
+			"token_review_api_version": {
+				Type:        framework.TypeString,
+				Description: `Version of the authentication.k8s.io API the TokenReview requests are sent to. Allowed values: "v1" and "v1beta1", for clusters older than 1.19. Defaults to "v1".`,
+				Default:     tokenReviewAPIVersionDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "TokenReview API version",
+				},
+			},
+			"annotation_collision_mode": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`What to do when several service account
+annotations normalise to the same metadata key, e.g. service-role and
+service_role. Allowed values: "%s" fails the login, "%s" and "%s" keep
+the value of the first or last of the annotations in key order. Defaults to
+"%s".`,
+					annotationCollisionError, annotationCollisionFirstWins, annotationCollisionLastWins, annotationCollisionDefault),
+				Default: annotationCollisionDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Annotation collision mode",
+				},
+			},
+			"max_num_uses": {
+				Type:        framework.TypeInt,
+				Description: "Optional ceiling on the token_num_uses of the roles. Role writes with a larger token_num_uses, or 0 which means unlimited uses, are rejected. Defaults to 0, which means no ceiling.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum number of uses",
+				},
+			},
+			"require_https_host": {
+				Type:        framework.TypeBool,
+				Description: "If true, kubernetes_host must use the https scheme, so that the Kubernetes API is never talked to over plaintext http.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Require HTTPS host",
+				},
+			},
+			"bind_token_to_remote_addr": {
+				Type:        framework.TypeBool,
+				Description: "If true, a projected token is bound to the remote address it is first used from, and logins with the same token from another address are denied until it expires. The bindings are kept in memory on each Vault node.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Bind token to remote address",
+				},
+			},
+			"default_token_review_audiences": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional list of the audiences the TokenReviews are performed for, unless the role sets an audience which is used instead. If unset, the audiences of the token are used.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Default TokenReview audiences",
+				},
+			},
+			"attestation_signing_key": {
+				Type:        framework.TypeString,
+				Description: "Optional PEM encoded RSA or ECDSA private key. When set, login responses include an attestation, a short lived JWT signed with it attesting the service account, namespace and role of the login, which can be verified offline.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Attestation signing key",
+				},
+			},
+			"lowercase_annotation_values": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag to lowercase the values of the metadata taken from service account and pod annotations, for policy engines matching them case-sensitively. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Lowercase annotation values",
+				},
+			},
+			"trust_token_review_for_expiry": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag for clusters with unreliable clocks. When set the exp, nbf and iat claims of the JWT are not checked locally and every login is checked with the TokenReview API, which alone decides whether the token is still valid. This reduces the assurance of the logins and is logged as such. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Trust TokenReview for expiry",
+				},
+			},
+			"include_timing": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag to include the milliseconds spent verifying the signature, validating the claims, performing the TokenReview and reading the annotations in the timings of the login responses. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Include timing",
+				},
+			},
+			"max_token_audiences": {
+				Type:        framework.TypeInt,
+				Description: "Optional maximum number of audiences in the aud claim of the JWT, tokens with more are rejected as suspicious. Set to 0 for no limit. Defaults to 64.",
+				Default:     defaultMaxTokenAudiences,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Maximum token audiences",
+				},
+			},
+			"role_claim": {
+				Type:        framework.TypeString,
+				Description: "Optional dot separated path of the JWT claim the role is read from when a login omits it, e.g. for roles injected by an admission controller. Only the roles in claim_selectable_roles can be selected this way.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Role claim",
+				},
+			},
+			"claim_selectable_roles": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Optional list of the roles which can be selected by the role_claim of the JWT, required with role_claim.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Claim selectable roles",
+				},
+			},
+			"min_rsa_key_bits": {
+				Type:        framework.TypeInt,
+				Description: "Optional minimum size in bits of the RSA public keys in pem_keys, smaller keys are rejected. Set to 0 for no minimum. Defaults to 2048.",
+				Default:     defaultMinRSAKeyBits,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Minimum RSA key bits",
+				},
+			},
+			"validate_namespace_exists": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag to deny logins from namespaces which don't exist in the cluster, e.g. with tokens of a deleted namespace whose signature still verifies. The namespaces found are cached briefly. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Validate namespace exists",
+				},
+			},
+			"claim_parse_order": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`The order in which the claims of a token are tried
+to identify its service account, for clusters issuing both projected and
+legacy tokens. Allowed values: "%s" reads the kubernetes.io claims of
+projected tokens before falling back to the kubernetes.io/serviceaccount/*
+claims of legacy tokens, "%s" the reverse. Defaults to "%s".`,
+					claimParseOrderProjectedFirst, claimParseOrderLegacyFirst, claimParseOrderDefault),
+				Default: claimParseOrderDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Claim parse order",
+				},
+			},
+			"enable_pod_annotation_metadata": {
+				Type:        framework.TypeBool,
+				Description: "Optional flag to add the annotations carrying pod_annotation_prefix of the pod a projected token is bound to to the token metadata. Service account annotations take precedence over pod annotations normalising to the same key. Defaults to false.",
+				Default:     false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Enable pod annotation metadata",
+				},
+			},
+			"pod_annotation_prefix": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`The prefix of the pod annotations added to the token
+metadata when enable_pod_annotation_metadata is set, their keys are
+normalised like those of service account annotations. Defaults to "%s".`, allowedAnnotationPrefix),
+				Default: allowedAnnotationPrefix,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Pod annotation prefix",
+				},
+			},
+			"token_review_unreachable_mode": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`What to do when the TokenReview of a login can't
+reach the kubernetes API, or the API fails to process it, e.g. during an
+apiserver outage. Allowed values: "%s" denies the login, "%s" logs in with a
+warning if the signature of the token was verified against pem_keys, and
+requires pem_keys or pem_keys_dir. Defaults to "%s".`,
+					tokenReviewUnreachableFail, tokenReviewUnreachableFailOpen, tokenReviewUnreachableDefault),
+				Default: tokenReviewUnreachableDefault,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "TokenReview unreachable mode",
+				},
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.UpdateOperation: b.pathConfigWrite,
@@ -119,9 +615,81 @@ func (b *kubeAuthBackend) pathConfigRead(ctx context.Context, req *logical.Reque
 				"disable_iss_validation": config.DisableISSValidation,
 				"disable_local_ca_jwt":   config.DisableLocalCAJwt,
 				"enable_custom_metadata_from_annotations": config.EnableCustomMetadataFromAnnotations,
+				"require_tls_connection":                  config.RequireTLSConnection,
+				"allow_default_service_account":           config.AllowDefaultServiceAccount,
+				"not_before_leeway":                       int64(config.NotBeforeLeeway.Seconds()),
+				"max_roles":                               config.MaxRoles,
+				"kubernetes_tls_server_name":              config.TLSServerName,
+				"pem_keys_dir":                            config.PEMKeysDir,
+				"require_service_account_subject":         config.RequireServiceAccountSubject,
+				"max_future_iat":                          int64(config.MaxFutureIAT.Seconds()),
+				"strict_role_fields":                      config.StrictRoleFields,
+				"min_kubernetes_version":                  config.MinKubernetesVersion,
+				"lowercase_namespace_name_matching":       config.LowercaseNamespaceNameMatching,
+				"required_claims":                         config.RequiredClaims,
+				"verbose_denials":                         config.VerboseDenials,
+				"token_review_for_projected_only":         config.TokenReviewForProjectedOnly,
+				"common_policies":                         config.CommonPolicies,
+				"verification_precedence":                 config.VerificationPrecedence,
+				"namespace_prefix_strip":                  config.NamespacePrefixStrip,
+				"max_metadata_bytes":                      config.MaxMetadataBytes,
+				"max_metadata_overflow":                   config.MaxMetadataOverflow,
+				"role_aliases":                            config.RoleAliases,
+				"propagate_audit_id":                      config.PropagateAuditID,
+				"legacy_error_codes":                      config.LegacyErrorCodes,
+				"warn_on_legacy_token":                    config.WarnOnLegacyToken,
+				"require_exp_claim":                       config.RequireExpClaim,
+				"verify_x5c":                              config.VerifyX5C,
+				"strict_sa_read_namespace":                config.StrictSAReadNamespace,
+				"role_reconcile_interval":                 int64(config.RoleReconcileInterval.Seconds()),
+				"require_kid":                             config.RequireKid,
+				"sa_not_found_metadata_mode":              config.SANotFoundMetadataMode,
+				"jwt_field_name":                          config.JWTFieldName,
+				"cluster_name":                            config.ClusterName,
+				"api_idle_conn_timeout":                   int64(config.APIIdleConnTimeout.Seconds()),
+				"api_disable_keepalives":                  config.APIDisableKeepAlives,
+				"echoable_claims":                         config.EchoableClaims,
+				"strict_projected_claims":                 config.StrictProjectedClaims,
+				"warn_on_alias_collision":                 config.WarnOnAliasCollision,
+				"global_login_concurrency":                config.GlobalLoginConcurrency,
+				"fair_concurrency":                        config.FairConcurrency,
+				"reject_empty_policy_roles":               config.RejectEmptyPolicyRoles,
+				"token_review_api_version":                config.TokenReviewAPIVersion,
+				"annotation_collision_mode":               config.AnnotationCollisionMode,
+				"max_num_uses":                            config.MaxNumUses,
+				"require_https_host":                      config.RequireHTTPSHost,
+				"bind_token_to_remote_addr":               config.BindTokenToRemoteAddr,
+				"default_token_review_audiences":          config.DefaultTokenReviewAudiences,
+				"lowercase_annotation_values":             config.LowercaseAnnotationValues,
+				"trust_token_review_for_expiry":           config.TrustTokenReviewForExpiry,
+				"include_timing":                          config.IncludeTiming,
+				"max_token_audiences":                     config.MaxTokenAudiences,
+				"role_claim":                              config.RoleClaim,
+				"claim_selectable_roles":                  config.ClaimSelectableRoles,
+				"min_rsa_key_bits":                        config.MinRSAKeyBits,
+				"validate_namespace_exists":               config.ValidateNamespaceExists,
+				"claim_parse_order":                       config.ClaimParseOrder,
+				"enable_pod_annotation_metadata":          config.EnablePodAnnotationMetadata,
+				"pod_annotation_prefix":                   config.PodAnnotationPrefix,
+				"token_review_unreachable_mode":           config.TokenReviewUnreachableMode,
+				"export":                                  config.export(),
 			},
 		}
 
+		// The version check is advisory: failing to reach the apiserver must
+		// not prevent the config from being read.
+		if config.MinKubernetesVersion != "" {
+			version, err := readKubernetesVersion(ctx, config)
+			if err != nil {
+				resp.AddWarning(fmt.Sprintf("unable to determine kubernetes version: %v", err))
+			} else {
+				resp.Data["kubernetes_version"] = version
+				if warning := kubernetesVersionWarning(config.MinKubernetesVersion, version); warning != "" {
+					resp.AddWarning(warning)
+				}
+			}
+		}
+
 		return resp, nil
 	}
 }
@@ -140,6 +708,87 @@ func (b *kubeAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Requ
 	disableIssValidation := data.Get("disable_iss_validation").(bool)
 	tokenReviewer := data.Get("token_reviewer_jwt").(string)
 	enableCustomMetadata := data.Get("enable_custom_metadata_from_annotations").(bool)
+	requireTLSConnection := data.Get("require_tls_connection").(bool)
+	allowDefaultSA := data.Get("allow_default_service_account").(bool)
+	notBeforeLeeway := time.Duration(data.Get("not_before_leeway").(int)) * time.Second
+	maxRoles := data.Get("max_roles").(int)
+	tlsServerName := data.Get("kubernetes_tls_server_name").(string)
+	pemKeysDir := data.Get("pem_keys_dir").(string)
+	requireSASubject := data.Get("require_service_account_subject").(bool)
+	maxFutureIAT := time.Duration(data.Get("max_future_iat").(int)) * time.Second
+	strictRoleFields := data.Get("strict_role_fields").(bool)
+	minKubernetesVersion := data.Get("min_kubernetes_version").(string)
+	lowercaseMatching := data.Get("lowercase_namespace_name_matching").(bool)
+	requiredClaims := data.Get("required_claims").([]string)
+	verboseDenials := data.Get("verbose_denials").(bool)
+	tokenReviewForProjectedOnly := data.Get("token_review_for_projected_only").(bool)
+	commonPolicies := data.Get("common_policies").([]string)
+	verificationPrecedence := data.Get("verification_precedence").(string)
+	namespacePrefixStrip := data.Get("namespace_prefix_strip").(string)
+	saReadToken := data.Get("sa_read_token").(string)
+	maxMetadataBytes := data.Get("max_metadata_bytes").(int)
+	maxMetadataOverflow := data.Get("max_metadata_overflow").(string)
+	roleAliases := data.Get("role_aliases").(map[string]string)
+	propagateAuditID := data.Get("propagate_audit_id").(bool)
+	legacyErrorCodes := data.Get("legacy_error_codes").(bool)
+	warnOnLegacyToken := data.Get("warn_on_legacy_token").(bool)
+	requireExpClaim := data.Get("require_exp_claim").(bool)
+	verifyX5C := data.Get("verify_x5c").(bool)
+	strictSAReadNamespace := data.Get("strict_sa_read_namespace").(bool)
+	roleReconcileInterval := time.Duration(data.Get("role_reconcile_interval").(int)) * time.Second
+	requireKid := data.Get("require_kid").(bool)
+	saNotFoundMetadataMode := data.Get("sa_not_found_metadata_mode").(string)
+	jwtFieldName := data.Get("jwt_field_name").(string)
+	clusterName := data.Get("cluster_name").(string)
+	apiIdleConnTimeout := time.Duration(data.Get("api_idle_conn_timeout").(int)) * time.Second
+	apiDisableKeepAlives := data.Get("api_disable_keepalives").(bool)
+	echoableClaims := data.Get("echoable_claims").([]string)
+	strictProjectedClaims := data.Get("strict_projected_claims").(bool)
+	warnOnAliasCollision := data.Get("warn_on_alias_collision").(bool)
+	globalLoginConcurrency := data.Get("global_login_concurrency").(int)
+	fairConcurrency := data.Get("fair_concurrency").(bool)
+	rejectEmptyPolicyRoles := data.Get("reject_empty_policy_roles").(bool)
+	tokenReviewAPIVersion := data.Get("token_review_api_version").(string)
+	annotationCollisionMode := data.Get("annotation_collision_mode").(string)
+	maxNumUses := data.Get("max_num_uses").(int)
+	requireHTTPSHost := data.Get("require_https_host").(bool)
+	bindTokenToRemoteAddr := data.Get("bind_token_to_remote_addr").(bool)
+	defaultTokenReviewAudiences := data.Get("default_token_review_audiences").([]string)
+	attestationSigningKey := data.Get("attestation_signing_key").(string)
+	lowercaseAnnotationValues := data.Get("lowercase_annotation_values").(bool)
+	trustTokenReviewForExpiry := data.Get("trust_token_review_for_expiry").(bool)
+	includeTiming := data.Get("include_timing").(bool)
+	maxTokenAudiences := data.Get("max_token_audiences").(int)
+	roleClaim := data.Get("role_claim").(string)
+	claimSelectableRoles := data.Get("claim_selectable_roles").([]string)
+	minRSAKeyBits := data.Get("min_rsa_key_bits").(int)
+	validateNamespaceExists := data.Get("validate_namespace_exists").(bool)
+	claimParseOrder := data.Get("claim_parse_order").(string)
+	enablePodAnnotationMetadata := data.Get("enable_pod_annotation_metadata").(bool)
+	podAnnotationPrefix := data.Get("pod_annotation_prefix").(string)
+	tokenReviewUnreachableMode := data.Get("token_review_unreachable_mode").(string)
+
+	// An exported config carries placeholders rather than the reviewer JWT,
+	// the service account read token and the attestation signing key, keep the
+	// stored ones so that the export can be written back verbatim.
+	if tokenReviewer == redactedPlaceholder || saReadToken == redactedPlaceholder || attestationSigningKey == redactedPlaceholder {
+		existing, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return logical.ErrorResponse("token_reviewer_jwt, sa_read_token or attestation_signing_key is redacted but no config exists to preserve it from"), nil
+		}
+		if tokenReviewer == redactedPlaceholder {
+			tokenReviewer = existing.TokenReviewerJWT
+		}
+		if saReadToken == redactedPlaceholder {
+			saReadToken = existing.SAReadToken
+		}
+		if attestationSigningKey == redactedPlaceholder {
+			attestationSigningKey = existing.AttestationSigningKey
+		}
+	}
 
 	if tokenReviewer != "" {
 		// Validate it's a JWT
@@ -153,6 +802,115 @@ func (b *kubeAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Requ
 		return logical.ErrorResponse("kubernetes_ca_cert must be given when disable_local_ca_jwt is true"), nil
 	}
 
+	if notBeforeLeeway < 0 {
+		return logical.ErrorResponse("not_before_leeway can not be negative"), nil
+	}
+
+	if maxRoles < 0 {
+		return logical.ErrorResponse("max_roles can not be negative"), nil
+	}
+
+	if maxFutureIAT < 0 {
+		return logical.ErrorResponse("max_future_iat can not be negative"), nil
+	}
+
+	if roleReconcileInterval < 0 {
+		return logical.ErrorResponse("role_reconcile_interval can not be negative"), nil
+	}
+
+	if err := validateVerificationPrecedence(verificationPrecedence); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if maxMetadataBytes < 0 {
+		return logical.ErrorResponse("max_metadata_bytes can not be negative"), nil
+	}
+
+	if requireHTTPSHost {
+		if u, err := url.Parse(host); err != nil || u.Scheme != "https" {
+			return logical.ErrorResponse("kubernetes_host %q must use the https scheme when require_https_host is set", host), nil
+		}
+	}
+
+	if maxNumUses < 0 {
+		return logical.ErrorResponse("max_num_uses can not be negative"), nil
+	}
+
+	if maxTokenAudiences < 0 {
+		return logical.ErrorResponse("max_token_audiences can not be negative"), nil
+	}
+
+	if minRSAKeyBits < 0 {
+		return logical.ErrorResponse("min_rsa_key_bits can not be negative"), nil
+	}
+
+	// Role names are case insensitive.
+	claimSelectableRoles = strutil.RemoveDuplicates(claimSelectableRoles, true)
+	if roleClaim != "" && len(claimSelectableRoles) == 0 {
+		return logical.ErrorResponse("claim_selectable_roles must be set with role_claim"), nil
+	}
+
+	if globalLoginConcurrency < 0 {
+		return logical.ErrorResponse("global_login_concurrency can not be negative"), nil
+	}
+
+	if err := validateMetadataOverflow(maxMetadataOverflow); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateSANotFoundMetadataMode(saNotFoundMetadataMode); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateTokenReviewAPIVersion(tokenReviewAPIVersion); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateAnnotationCollisionMode(annotationCollisionMode); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateClaimParseOrder(claimParseOrder); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateTokenReviewUnreachableMode(tokenReviewUnreachableMode); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Failing open relies on the signature being verified instead.
+	if tokenReviewUnreachableMode == tokenReviewUnreachableFailOpen && len(pemList) == 0 && pemKeysDir == "" {
+		return logical.ErrorResponse("token_review_unreachable_mode %q requires pem_keys or pem_keys_dir", tokenReviewUnreachableFailOpen), nil
+	}
+
+	// Without a prefix every annotation of the pod would end up in the token
+	// metadata.
+	if podAnnotationPrefix == "" {
+		return logical.ErrorResponse("pod_annotation_prefix can not be empty"), nil
+	}
+
+	// The JWT can't be read from a field the login schema already uses.
+	if jwtFieldName == "" || (jwtFieldName != "jwt" && pathLogin(b).Fields[jwtFieldName] != nil) {
+		return logical.ErrorResponse("invalid jwt_field_name %q", jwtFieldName), nil
+	}
+
+	if namespacePrefixStrip != "" {
+		if _, err := b.compileNamespacePrefixStrip(namespacePrefixStrip); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	roleAliases, err := normaliseRoleAliases(roleAliases)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if minKubernetesVersion != "" {
+		if _, err := parseKubernetesVersion(minKubernetesVersion); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
 	config := &kubeConfig{
 		PublicKeys:                          make([]interface{}, len(pemList)),
 		PEMKeys:                             pemList,
@@ -163,14 +921,82 @@ func (b *kubeAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Requ
 		DisableISSValidation:                disableIssValidation,
 		DisableLocalCAJwt:                   disableLocalJWT,
 		EnableCustomMetadataFromAnnotations: enableCustomMetadata,
+		RequireTLSConnection:                requireTLSConnection,
+		AllowDefaultServiceAccount:          allowDefaultSA,
+		NotBeforeLeeway:                     notBeforeLeeway,
+		MaxRoles:                            maxRoles,
+		TLSServerName:                       tlsServerName,
+		PEMKeysDir:                          pemKeysDir,
+		RequireServiceAccountSubject:        requireSASubject,
+		MaxFutureIAT:                        maxFutureIAT,
+		StrictRoleFields:                    strictRoleFields,
+		MinKubernetesVersion:                minKubernetesVersion,
+		LowercaseNamespaceNameMatching:      lowercaseMatching,
+		RequiredClaims:                      requiredClaims,
+		VerboseDenials:                      verboseDenials,
+		TokenReviewForProjectedOnly:         tokenReviewForProjectedOnly,
+		CommonPolicies:                      commonPolicies,
+		VerificationPrecedence:              verificationPrecedence,
+		NamespacePrefixStrip:                namespacePrefixStrip,
+		SAReadToken:                         saReadToken,
+		MaxMetadataBytes:                    maxMetadataBytes,
+		MaxMetadataOverflow:                 maxMetadataOverflow,
+		RoleAliases:                         roleAliases,
+		PropagateAuditID:                    propagateAuditID,
+		LegacyErrorCodes:                    legacyErrorCodes,
+		WarnOnLegacyToken:                   warnOnLegacyToken,
+		RequireExpClaim:                     requireExpClaim,
+		VerifyX5C:                           verifyX5C,
+		StrictSAReadNamespace:               strictSAReadNamespace,
+		RoleReconcileInterval:               roleReconcileInterval,
+		RequireKid:                          requireKid,
+		SANotFoundMetadataMode:              saNotFoundMetadataMode,
+		JWTFieldName:                        jwtFieldName,
+		ClusterName:                         clusterName,
+		APIIdleConnTimeout:                  apiIdleConnTimeout,
+		APIDisableKeepAlives:                apiDisableKeepAlives,
+		EchoableClaims:                      echoableClaims,
+		StrictProjectedClaims:               strictProjectedClaims,
+		WarnOnAliasCollision:                warnOnAliasCollision,
+		GlobalLoginConcurrency:              globalLoginConcurrency,
+		FairConcurrency:                     fairConcurrency,
+		RejectEmptyPolicyRoles:              rejectEmptyPolicyRoles,
+		TokenReviewAPIVersion:               tokenReviewAPIVersion,
+		AnnotationCollisionMode:             annotationCollisionMode,
+		MaxNumUses:                          maxNumUses,
+		RequireHTTPSHost:                    requireHTTPSHost,
+		BindTokenToRemoteAddr:               bindTokenToRemoteAddr,
+		DefaultTokenReviewAudiences:         defaultTokenReviewAudiences,
+		AttestationSigningKey:               attestationSigningKey,
+		LowercaseAnnotationValues:           lowercaseAnnotationValues,
+		TrustTokenReviewForExpiry:           trustTokenReviewForExpiry,
+		IncludeTiming:                       includeTiming,
+		MaxTokenAudiences:                   maxTokenAudiences,
+		RoleClaim:                           roleClaim,
+		ClaimSelectableRoles:                claimSelectableRoles,
+		MinRSAKeyBits:                       minRSAKeyBits,
+		ValidateNamespaceExists:             validateNamespaceExists,
+		ClaimParseOrder:                     claimParseOrder,
+		EnablePodAnnotationMetadata:         enablePodAnnotationMetadata,
+		PodAnnotationPrefix:                 podAnnotationPrefix,
+		TokenReviewUnreachableMode:          tokenReviewUnreachableMode,
+		Version:                             currentConfigVersion,
 	}
 
-	var err error
 	for i, pem := range pemList {
 		config.PublicKeys[i], err = parsePublicKeyPEM([]byte(pem))
 		if err != nil {
 			return logical.ErrorResponse(err.Error()), nil
 		}
+		if key, ok := config.PublicKeys[i].(*rsa.PublicKey); ok && key.N.BitLen() < minRSAKeyBits {
+			return logical.ErrorResponse(fmt.Sprintf("pem_keys[%d] is a %d-bit RSA key, smaller than the %d bits required by min_rsa_key_bits", i, key.N.BitLen(), minRSAKeyBits)), nil
+		}
+	}
+
+	if attestationSigningKey != "" {
+		if _, _, err := parseSigningKeyPEM([]byte(attestationSigningKey)); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid attestation_signing_key: %s", err)), nil
+		}
 	}
 
 	entry, err := logical.StorageEntryJSON(configPath, config)
@@ -184,6 +1010,124 @@ func (b *kubeAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Requ
 	return nil, nil
 }
 
+// export returns the config in a normalised form which can be written back to
+// the config endpoint verbatim, with secrets replaced by redactedPlaceholder.
+func (c *kubeConfig) export() map[string]interface{} {
+	d := map[string]interface{}{
+		"kubernetes_host":        c.Host,
+		"kubernetes_ca_cert":     c.CACert,
+		"pem_keys":               c.PEMKeys,
+		"issuer":                 c.Issuer,
+		"disable_iss_validation": c.DisableISSValidation,
+		"disable_local_ca_jwt":   c.DisableLocalCAJwt,
+		"enable_custom_metadata_from_annotations": c.EnableCustomMetadataFromAnnotations,
+		"require_tls_connection":                  c.RequireTLSConnection,
+		"allow_default_service_account":           c.AllowDefaultServiceAccount,
+		"not_before_leeway":                       int64(c.NotBeforeLeeway.Seconds()),
+		"max_roles":                               c.MaxRoles,
+		"kubernetes_tls_server_name":              c.TLSServerName,
+		"pem_keys_dir":                            c.PEMKeysDir,
+		"require_service_account_subject":         c.RequireServiceAccountSubject,
+		"max_future_iat":                          int64(c.MaxFutureIAT.Seconds()),
+		"strict_role_fields":                      c.StrictRoleFields,
+		"min_kubernetes_version":                  c.MinKubernetesVersion,
+		"lowercase_namespace_name_matching":       c.LowercaseNamespaceNameMatching,
+		"required_claims":                         c.RequiredClaims,
+		"verbose_denials":                         c.VerboseDenials,
+		"token_review_for_projected_only":         c.TokenReviewForProjectedOnly,
+		"common_policies":                         c.CommonPolicies,
+		"verification_precedence":                 c.VerificationPrecedence,
+		"namespace_prefix_strip":                  c.NamespacePrefixStrip,
+		"max_metadata_bytes":                      c.MaxMetadataBytes,
+		"max_metadata_overflow":                   c.MaxMetadataOverflow,
+		"role_aliases":                            c.RoleAliases,
+		"propagate_audit_id":                      c.PropagateAuditID,
+		"legacy_error_codes":                      c.LegacyErrorCodes,
+		"warn_on_legacy_token":                    c.WarnOnLegacyToken,
+		"require_exp_claim":                       c.RequireExpClaim,
+		"verify_x5c":                              c.VerifyX5C,
+		"strict_sa_read_namespace":                c.StrictSAReadNamespace,
+		"role_reconcile_interval":                 int64(c.RoleReconcileInterval.Seconds()),
+		"require_kid":                             c.RequireKid,
+		"sa_not_found_metadata_mode":              c.SANotFoundMetadataMode,
+		"jwt_field_name":                          c.JWTFieldName,
+		"cluster_name":                            c.ClusterName,
+		"api_idle_conn_timeout":                   int64(c.APIIdleConnTimeout.Seconds()),
+		"api_disable_keepalives":                  c.APIDisableKeepAlives,
+		"echoable_claims":                         c.EchoableClaims,
+		"strict_projected_claims":                 c.StrictProjectedClaims,
+		"warn_on_alias_collision":                 c.WarnOnAliasCollision,
+		"global_login_concurrency":                c.GlobalLoginConcurrency,
+		"fair_concurrency":                        c.FairConcurrency,
+		"reject_empty_policy_roles":               c.RejectEmptyPolicyRoles,
+		"token_review_api_version":                c.TokenReviewAPIVersion,
+		"annotation_collision_mode":               c.AnnotationCollisionMode,
+		"max_num_uses":                            c.MaxNumUses,
+		"require_https_host":                      c.RequireHTTPSHost,
+		"bind_token_to_remote_addr":               c.BindTokenToRemoteAddr,
+		"default_token_review_audiences":          c.DefaultTokenReviewAudiences,
+		"lowercase_annotation_values":             c.LowercaseAnnotationValues,
+		"trust_token_review_for_expiry":           c.TrustTokenReviewForExpiry,
+		"include_timing":                          c.IncludeTiming,
+		"max_token_audiences":                     c.MaxTokenAudiences,
+		"role_claim":                              c.RoleClaim,
+		"claim_selectable_roles":                  c.ClaimSelectableRoles,
+		"min_rsa_key_bits":                        c.MinRSAKeyBits,
+		"validate_namespace_exists":               c.ValidateNamespaceExists,
+		"claim_parse_order":                       c.ClaimParseOrder,
+		"enable_pod_annotation_metadata":          c.EnablePodAnnotationMetadata,
+		"pod_annotation_prefix":                   c.PodAnnotationPrefix,
+		"token_review_unreachable_mode":           c.TokenReviewUnreachableMode,
+	}
+
+	if c.TokenReviewerJWT != "" {
+		d["token_reviewer_jwt"] = redactedPlaceholder
+	}
+	if c.SAReadToken != "" {
+		d["sa_read_token"] = redactedPlaceholder
+	}
+	if c.AttestationSigningKey != "" {
+		d["attestation_signing_key"] = redactedPlaceholder
+	}
+
+	return d
+}
+
+// tlsConfig returns the TLS configuration used to talk to the Kubernetes API,
+// or nil if the defaults should be used.
+func (c *kubeConfig) tlsConfig() *tls.Config {
+	if len(c.CACert) == 0 && c.TLSServerName == "" {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.TLSServerName,
+	}
+
+	// If we have a CA cert add it to the pool
+	if len(c.CACert) > 0 {
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM([]byte(c.CACert))
+		tlsConfig.RootCAs = certPool
+	}
+
+	return tlsConfig
+}
+
+// configureTransport applies the TLS and connection settings used to talk to
+// the Kubernetes API to t.
+func (c *kubeConfig) configureTransport(t *http.Transport) {
+	// If we have a CA cert or server name set the TLSConfig
+	if tlsConfig := c.tlsConfig(); tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	if c.APIIdleConnTimeout > 0 {
+		t.IdleConnTimeout = c.APIIdleConnTimeout
+	}
+	t.DisableKeepAlives = c.APIDisableKeepAlives
+}
+
 // kubeConfig contains the public key certificate used to verify the signature
 // on the service account JWTs
 type kubeConfig struct {
@@ -209,6 +1153,180 @@ type kubeConfig struct {
 	// EnableCustomMetadataFromAnnotations is an optional parameter which will cause
 	// us to read the kubernetes ServiceAccount's annotations as metadata of auth alias.
 	EnableCustomMetadataFromAnnotations bool `json:"enable_custom_metadata_from_annotations"`
+	// RequireTLSConnection is an optional parameter to reject logins where the
+	// request did not arrive over a TLS connection.
+	RequireTLSConnection bool `json:"require_tls_connection"`
+	// AllowDefaultServiceAccount controls whether tokens for the "default"
+	// service account are allowed to log in, regardless of role bindings.
+	AllowDefaultServiceAccount bool `json:"allow_default_service_account"`
+	// NotBeforeLeeway is the clock skew tolerated when validating the nbf
+	// claim, independently of expiry validation.
+	NotBeforeLeeway time.Duration `json:"not_before_leeway"`
+	// MaxRoles is an optional cap on the number of roles which can be created,
+	// 0 means unlimited.
+	MaxRoles int `json:"max_roles"`
+	// TLSServerName is an optional name used to verify the Kubernetes API
+	// server's certificate in place of the host being dialed.
+	TLSServerName string `json:"kubernetes_tls_server_name"`
+	// PEMKeysDir is an optional directory which is periodically scanned for
+	// public key PEM files, used in addition to PEMKeys.
+	PEMKeysDir string `json:"pem_keys_dir"`
+	// RequireServiceAccountSubject rejects JWTs whose sub claim is not a
+	// service account subject.
+	RequireServiceAccountSubject bool `json:"require_service_account_subject"`
+	// MaxFutureIAT optionally bounds how far ahead of the current time the iat
+	// claim of a JWT may be, 0 disables the check.
+	MaxFutureIAT time.Duration `json:"max_future_iat"`
+	// StrictRoleFields rejects role writes containing unknown fields.
+	StrictRoleFields bool `json:"strict_role_fields"`
+	// MinKubernetesVersion is the oldest apiserver version config reads
+	// do not warn about, it is advisory only.
+	MinKubernetesVersion string `json:"min_kubernetes_version"`
+	// LowercaseNamespaceNameMatching lowercases the namespace and service
+	// account name taken from the JWT before they are matched or looked up.
+	LowercaseNamespaceNameMatching bool `json:"lowercase_namespace_name_matching"`
+	// RequiredClaims are the dot separated paths of the claims which a
+	// JWT must carry to be accepted.
+	RequiredClaims []string `json:"required_claims"`
+	// VerboseDenials adds the role's bindings and the JWT's values to the
+	// errors of logins denied for a namespace or service account name mismatch.
+	VerboseDenials bool `json:"verbose_denials"`
+	// TokenReviewForProjectedOnly skips the TokenReview of legacy tokens
+	// verified with the configured public keys.
+	TokenReviewForProjectedOnly bool `json:"token_review_for_projected_only"`
+	// CommonPolicies are added to the policies of the tokens issued for
+	// every role.
+	CommonPolicies []string `json:"common_policies"`
+	// VerificationPrecedence decides the outcome of a login when the
+	// signature verification and the TokenReview disagree.
+	VerificationPrecedence string `json:"verification_precedence"`
+	// NamespacePrefixStrip is the regular expression splitting namespaces
+	// into a tenant and the namespace matched against role bindings.
+	NamespacePrefixStrip string `json:"namespace_prefix_strip"`
+	// NamespacePrefixStripRegexp is the compiled NamespacePrefixStrip.
+	NamespacePrefixStripRegexp *regexp.Regexp `json:"-"`
+	// SAReadToken is the bearer used to read service accounts, falling back
+	// to TokenReviewerJWT when empty.
+	SAReadToken string `json:"sa_read_token"`
+	// MaxMetadataBytes caps the total size of the metadata taken from
+	// service account annotations, 0 disables the cap.
+	MaxMetadataBytes int `json:"max_metadata_bytes"`
+	// MaxMetadataOverflow decides what happens to logins whose annotation
+	// metadata exceeds MaxMetadataBytes.
+	MaxMetadataOverflow string `json:"max_metadata_overflow"`
+	// RoleAliases maps alternative, lowercased, role names to the role
+	// logins with them resolve to.
+	RoleAliases map[string]string `json:"role_aliases"`
+	// PropagateAuditID adds the audit ids of the kubernetes API requests
+	// made for a login to its token metadata.
+	PropagateAuditID bool `json:"propagate_audit_id"`
+	// LegacyErrorCodes downgrades the statuses of denied logins to the ones
+	// older clients expect, see legacyStatusCodes.
+	LegacyErrorCodes bool `json:"legacy_error_codes"`
+	// WarnOnLegacyToken warns about, and counts, successful logins with
+	// legacy service account tokens.
+	WarnOnLegacyToken bool `json:"warn_on_legacy_token"`
+	// RequireExpClaim rejects JWTs which never expire.
+	RequireExpClaim bool `json:"require_exp_claim"`
+	// VerifyX5C checks the embedded certificate headers of JWTs against
+	// PublicKeys, and their thumbprints against the certificates of PEMKeys.
+	VerifyX5C bool `json:"verify_x5c"`
+	// StrictSAReadNamespace denies logins whose annotations were read from a
+	// namespace other than the validated namespace claim.
+	StrictSAReadNamespace bool `json:"strict_sa_read_namespace"`
+	// RoleReconcileInterval is how often the roles bound to no live namespace
+	// are looked for, 0 disables it.
+	RoleReconcileInterval time.Duration `json:"role_reconcile_interval"`
+	// RequireKid rejects JWTs without a kid header.
+	RequireKid bool `json:"require_kid"`
+	// SANotFoundMetadataMode decides what happens to logins whose service
+	// account is not found when reading its annotations.
+	SANotFoundMetadataMode string `json:"sa_not_found_metadata_mode"`
+	// JWTFieldName is the login request field the JWT is read from.
+	JWTFieldName string `json:"jwt_field_name"`
+	// ClusterName is added to the metadata of every login when set.
+	ClusterName string `json:"cluster_name"`
+	// APIIdleConnTimeout is how long idle connections to the kubernetes API
+	// are kept open, zero meaning the client default.
+	APIIdleConnTimeout time.Duration `json:"api_idle_conn_timeout"`
+	// APIDisableKeepAlives disables the reuse of connections to the
+	// kubernetes API.
+	APIDisableKeepAlives bool `json:"api_disable_keepalives"`
+	// EchoableClaims are the dot separated paths of the claims which a login
+	// may have returned with return_claims.
+	EchoableClaims []string `json:"echoable_claims"`
+	// StrictProjectedClaims rejects projected tokens with an incomplete
+	// kubernetes.io claim.
+	StrictProjectedClaims bool `json:"strict_projected_claims"`
+	// WarnOnAliasCollision warns at role write about roles resolving to the
+	// same aliases with different policies.
+	WarnOnAliasCollision bool `json:"warn_on_alias_collision"`
+	// GlobalLoginConcurrency limits the concurrent logins checked against the
+	// Kubernetes API across all roles.
+	GlobalLoginConcurrency int `json:"global_login_concurrency"`
+	// FairConcurrency admits the logins waiting for GlobalLoginConcurrency
+	// round-robin across namespaces.
+	FairConcurrency bool `json:"fair_concurrency"`
+	// RejectEmptyPolicyRoles fails role writes without policies when no
+	// common policies are configured.
+	RejectEmptyPolicyRoles bool `json:"reject_empty_policy_roles"`
+	// TokenReviewAPIVersion is the version of the authentication.k8s.io API
+	// used for TokenReviews.
+	TokenReviewAPIVersion string `json:"token_review_api_version"`
+	// AnnotationCollisionMode decides what happens to annotations normalising
+	// to the same metadata key.
+	AnnotationCollisionMode string `json:"annotation_collision_mode"`
+	// MaxNumUses caps the token_num_uses of the roles, rejecting unlimited
+	// uses.
+	MaxNumUses int `json:"max_num_uses"`
+	// RequireHTTPSHost rejects a Host without the https scheme.
+	RequireHTTPSHost bool `json:"require_https_host"`
+	// BindTokenToRemoteAddr denies logins reusing a projected token from
+	// another remote address than the first.
+	BindTokenToRemoteAddr bool `json:"bind_token_to_remote_addr"`
+	// DefaultTokenReviewAudiences are the audiences of the TokenReviews of roles
+	// without an audience.
+	DefaultTokenReviewAudiences []string `json:"default_token_review_audiences"`
+	// AttestationSigningKey is the PEM encoded private key signing the
+	// attestations of the logins, see signAttestation.
+	AttestationSigningKey string `json:"attestation_signing_key"`
+	// LowercaseAnnotationValues lowercases the values of the metadata taken
+	// from service account annotations.
+	LowercaseAnnotationValues bool `json:"lowercase_annotation_values"`
+	// TrustTokenReviewForExpiry skips the local checks of the exp, nbf and
+	// iat claims, leaving the validity of the token to the TokenReview.
+	TrustTokenReviewForExpiry bool `json:"trust_token_review_for_expiry"`
+	// IncludeTiming adds the time spent in each stage of the login to the
+	// login response.
+	IncludeTiming bool `json:"include_timing"`
+	// MaxTokenAudiences rejects tokens with more audiences, 0 means no limit.
+	MaxTokenAudiences int `json:"max_token_audiences"`
+	// RoleClaim is the path of the claim the role of logins omitting it is
+	// read from, restricted to ClaimSelectableRoles.
+	RoleClaim string `json:"role_claim"`
+	// ClaimSelectableRoles are the lowercased roles RoleClaim can select.
+	ClaimSelectableRoles []string `json:"claim_selectable_roles"`
+	// MinRSAKeyBits is the minimum size of the RSA keys in PEMKeys, 0 means no
+	// minimum.
+	MinRSAKeyBits int `json:"min_rsa_key_bits"`
+	// ValidateNamespaceExists denies the logins of tokens whose namespace
+	// doesn't exist in the cluster.
+	ValidateNamespaceExists bool `json:"validate_namespace_exists"`
+	// ClaimParseOrder is the order in which the projected and legacy claims
+	// of a token are tried to identify its service account.
+	ClaimParseOrder string `json:"claim_parse_order"`
+	// EnablePodAnnotationMetadata adds the annotations of the pod a projected
+	// token is bound to to the token metadata.
+	EnablePodAnnotationMetadata bool `json:"enable_pod_annotation_metadata"`
+	// PodAnnotationPrefix is the prefix of the pod annotations added to the
+	// token metadata when EnablePodAnnotationMetadata is set.
+	PodAnnotationPrefix string `json:"pod_annotation_prefix"`
+	// TokenReviewUnreachableMode decides what happens to logins whose
+	// TokenReview can't reach the kubernetes API.
+	TokenReviewUnreachableMode string `json:"token_review_unreachable_mode"`
+
+	// Version is the version of the stored config, see upgradeConfig.
+	Version int `json:"version"`
 }
 
 // PasrsePublicKeyPEM is used to parse RSA and ECDSA public keys from PEMs
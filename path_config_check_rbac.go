@@ -0,0 +1,174 @@
+package kubeauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rbacCheck is a permission the backend needs in the kubernetes API.
+type rbacCheck struct {
+	name       string
+	attributes authzv1.ResourceAttributes
+	// token returns the credential the backend uses for the permission.
+	token func(*kubeConfig) string
+}
+
+var rbacChecks = []rbacCheck{
+	{
+		name: "create tokenreviews",
+		attributes: authzv1.ResourceAttributes{
+			Group:    "authentication.k8s.io",
+			Resource: "tokenreviews",
+			Verb:     "create",
+		},
+		token: func(c *kubeConfig) string {
+			return c.TokenReviewerJWT
+		},
+	},
+	{
+		name: "get serviceaccounts",
+		attributes: authzv1.ResourceAttributes{
+			Resource: "serviceaccounts",
+			Verb:     "get",
+		},
+		token: func(c *kubeConfig) string {
+			if c.SAReadToken != "" {
+				return c.SAReadToken
+			}
+			return c.TokenReviewerJWT
+		},
+	},
+}
+
+// pathConfigCheckRBAC returns the path checking the permissions of the
+// configured credentials in the kubernetes API.
+func pathConfigCheckRBAC(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/check-rbac$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigCheckRBACRead,
+		},
+		HelpSynopsis:    configCheckRBACHelpSyn,
+		HelpDescription: configCheckRBACHelpDesc,
+	}
+}
+
+func (b *kubeAuthBackend) pathConfigCheckRBACRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if config, err := b.config(ctx, req.Storage); err != nil {
+		return nil, err
+	} else if config == nil {
+		return logical.ErrorResponse("backend is not configured"), nil
+	}
+
+	config, err := b.loadConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	permissions := make(map[string]bool, len(rbacChecks))
+	for _, check := range rbacChecks {
+		token := check.token(config)
+		if token == "" {
+			resp.AddWarning(fmt.Sprintf("%s: no token_reviewer_jwt is configured, the JWT of each login is used instead and can not be checked", check.name))
+			continue
+		}
+
+		status, err := selfSubjectAccessReview(ctx, config, token, check.attributes)
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("%s: %v", check.name, err))
+			continue
+		}
+
+		permissions[check.name] = status.Allowed
+		if !status.Allowed {
+			warning := fmt.Sprintf("%s: permission missing", check.name)
+			if status.Reason != "" {
+				warning += ": " + status.Reason
+			}
+			resp.AddWarning(warning)
+		}
+	}
+
+	resp.Data = map[string]interface{}{
+		"permissions": permissions,
+	}
+	return resp, nil
+}
+
+// selfSubjectAccessReview asks the apiserver whether the bearer of token is
+// allowed to perform the request described by attributes.
+func selfSubjectAccessReview(ctx context.Context, config *kubeConfig, token string, attributes authzv1.ResourceAttributes) (*authzv1.SubjectAccessReviewStatus, error) {
+	client := cleanhttp.DefaultClient()
+	config.configureTransport(client.Transport.(*http.Transport))
+
+	review := &authzv1.SelfSubjectAccessReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "authorization.k8s.io/v1",
+			Kind:       "SelfSubjectAccessReview",
+		},
+		Spec: authzv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", strings.TrimSuffix(config.Host, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to talk to kubernetes API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read out body: %v", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, errors.New("credential rejected by the kubernetes API")
+	case resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent:
+		return nil, fmt.Errorf("unexpected status %d from kubernetes API: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	result := &authzv1.SelfSubjectAccessReview{}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into authzv1.SelfSubjectAccessReview: %v", err)
+	}
+	return &result.Status, nil
+}
+
+const configCheckRBACHelpSyn = `Checks the permissions of the configured credentials in the Kubernetes API.`
+const configCheckRBACHelpDesc = `
+Performs a SelfSubjectAccessReview for each permission the backend needs,
+"create tokenreviews" with the token_reviewer_jwt and "get serviceaccounts"
+with the sa_read_token, falling back to the token_reviewer_jwt. The
+permissions are returned with whether they are granted, and a warning is
+added for each missing permission or check which could not be performed.
+`
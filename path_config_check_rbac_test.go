@@ -0,0 +1,153 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	authzv1 "k8s.io/api/authorization/v1"
+)
+
+func TestConfig_CheckRBAC(t *testing.T) {
+	// allowed holds the permissions granted to each bearer token.
+	allowed := map[string][]string{
+		"Bearer " + jwtData:    {"create tokenreviews"},
+		"Bearer sa-read-token": {"get serviceaccounts"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		review := &authzv1.SelfSubjectAccessReview{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		permissions, ok := allowed[r.Header.Get("Authorization")]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		attributes := review.Spec.ResourceAttributes
+		permission := attributes.Verb + " " + attributes.Resource
+		for _, p := range permissions {
+			if p == permission {
+				review.Status.Allowed = true
+			}
+		}
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		config          map[string]interface{}
+		wantPermissions map[string]bool
+		wantWarnings    []string
+	}{
+		"reviewer jwt": {
+			config: map[string]interface{}{
+				"token_reviewer_jwt": jwtData,
+			},
+			wantPermissions: map[string]bool{
+				"create tokenreviews": true,
+				"get serviceaccounts": false,
+			},
+			wantWarnings: []string{"get serviceaccounts: permission missing: no RBAC policy matched"},
+		},
+		"sa read token": {
+			config: map[string]interface{}{
+				"token_reviewer_jwt": jwtData,
+				"sa_read_token":      "sa-read-token",
+			},
+			wantPermissions: map[string]bool{
+				"create tokenreviews": true,
+				"get serviceaccounts": true,
+			},
+		},
+		"rejected token": {
+			config: map[string]interface{}{
+				"token_reviewer_jwt": jwtData,
+				"sa_read_token":      "unknown",
+			},
+			wantPermissions: map[string]bool{
+				"create tokenreviews": true,
+			},
+			wantWarnings: []string{"get serviceaccounts: credential rejected by the kubernetes API"},
+		},
+		"no reviewer jwt": {
+			config: map[string]interface{}{
+				"disable_local_ca_jwt": true,
+			},
+			wantPermissions: map[string]bool{},
+			wantWarnings: []string{
+				"create tokenreviews: no token_reviewer_jwt is configured, the JWT of each login is used instead and can not be checked",
+				"get serviceaccounts: no token_reviewer_jwt is configured, the JWT of each login is used instead and can not be checked",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b, storage := getBackend(t)
+
+			data := map[string]interface{}{
+				"pem_keys":           testDefaultPEMs,
+				"kubernetes_host":    server.URL,
+				"kubernetes_ca_cert": testCACert,
+			}
+			for k, v := range tc.config {
+				data[k] = v
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "config/check-rbac",
+				Storage:   storage,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			if diff := deep.Equal(tc.wantPermissions, resp.Data["permissions"]); diff != nil {
+				t.Fatal(diff)
+			}
+			if diff := deep.Equal(tc.wantWarnings, resp.Warnings); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestConfig_CheckRBACNotConfigured(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/check-rbac",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || resp.Error().Error() != "backend is not configured" {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+}
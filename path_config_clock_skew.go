@@ -0,0 +1,129 @@
+package kubeauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigClockSkew returns the path measuring the skew between the local
+// clock and the one of the kubernetes apiserver.
+func pathConfigClockSkew(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/clock-skew$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigClockSkewRead,
+		},
+		HelpSynopsis:    configClockSkewHelpSyn,
+		HelpDescription: configClockSkewHelpDesc,
+	}
+}
+
+func (b *kubeAuthBackend) pathConfigClockSkewRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if config, err := b.config(ctx, req.Storage); err != nil {
+		return nil, err
+	} else if config == nil {
+		return logical.ErrorResponse("backend is not configured"), nil
+	}
+
+	config, err := b.loadConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	skew, err := measureClockSkew(ctx, config)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"server_time":  skew.serverTime.UTC().Format(time.RFC3339),
+			"local_time":   skew.localTime.UTC().Format(time.RFC3339),
+			"skew_seconds": skew.skew.Seconds(),
+			"round_trip":   skew.roundTrip.String(),
+		},
+	}
+	if math.Abs(skew.skew.Seconds()) > config.NotBeforeLeeway.Seconds() {
+		resp.AddWarning(fmt.Sprintf("the clock skew of %s exceeds the not_before_leeway of %s, freshly issued tokens may be rejected as not yet valid", skew.skew.Round(time.Second), config.NotBeforeLeeway))
+	}
+	return resp, nil
+}
+
+// clockSkew is the skew observed between the local clock and the apiserver's.
+type clockSkew struct {
+	serverTime time.Time
+	// localTime is the local time halfway through the request, when the
+	// apiserver is assumed to have answered.
+	localTime time.Time
+	// skew is positive when the apiserver's clock is ahead of the local one.
+	skew      time.Duration
+	roundTrip time.Duration
+}
+
+// measureClockSkew compares the Date header of a response of the configured
+// apiserver to the local time. The header has a resolution of a second, so is
+// the measured skew.
+func measureClockSkew(ctx context.Context, config *kubeConfig) (*clockSkew, error) {
+	client := cleanhttp.DefaultClient()
+
+	config.configureTransport(client.Transport.(*http.Transport))
+
+	url := fmt.Sprintf("%s/version", strings.TrimSuffix(config.Host, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Any response carries a Date header, the reviewer JWT is only sent in
+	// case anonymous access is disabled.
+	if config.TokenReviewerJWT != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(config.TokenReviewerJWT)))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to talk to kubernetes API: %v", err)
+	}
+	defer resp.Body.Close()
+	roundTrip := time.Since(start)
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return nil, errors.New("kubernetes API response has no Date header")
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the Date header of the kubernetes API response: %v", err)
+	}
+
+	localTime := start.Add(roundTrip / 2)
+	return &clockSkew{
+		serverTime: serverTime,
+		localTime:  localTime,
+		skew:       serverTime.Sub(localTime),
+		roundTrip:  roundTrip,
+	}, nil
+}
+
+const configClockSkewHelpSyn = `Measures the skew between the local clock and the Kubernetes API server's.`
+const configClockSkewHelpDesc = `
+Performs a request to the Kubernetes API and compares the Date header of its
+response to the local time halfway through the request. The skew is positive
+when the API server's clock is ahead, and is only accurate to a second. A
+warning is added when it exceeds the not_before_leeway, as tokens issued by
+the API server would then be rejected as not yet valid right after issuance.
+`
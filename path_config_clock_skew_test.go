@@ -0,0 +1,150 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfig_ClockSkew(t *testing.T) {
+	testCases := map[string]struct {
+		offset       time.Duration
+		leeway       int
+		wantWarnings []string
+	}{
+		"apiserver ahead": {
+			offset:       90 * time.Second,
+			leeway:       30,
+			wantWarnings: []string{"the clock skew of 1m30s exceeds the not_before_leeway of 30s, freshly issued tokens may be rejected as not yet valid"},
+		},
+		"apiserver behind": {
+			offset: -90 * time.Second,
+			leeway: 120,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Round to the second, as the Date header truncates.
+				w.Header().Set("Date", time.Now().Add(tc.offset+500*time.Millisecond).UTC().Format(http.TimeFormat))
+				w.Write([]byte(`{"gitVersion":"v1.30.0"}`))
+			}))
+			defer server.Close()
+
+			b, storage := getBackend(t)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"pem_keys":           testDefaultPEMs,
+					"kubernetes_host":    server.URL,
+					"kubernetes_ca_cert": testCACert,
+					"not_before_leeway":  tc.leeway,
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "config/clock-skew",
+				Storage:   storage,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			skew := resp.Data["skew_seconds"].(float64)
+			if want := tc.offset.Seconds(); skew < want-1 || skew > want+1 {
+				t.Fatalf("expected a skew of about %vs, got %vs", want, skew)
+			}
+			if diff := deep.Equal(tc.wantWarnings, resp.Warnings); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestConfig_ClockSkewLocalCAJWT(t *testing.T) {
+	var bearer string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer = r.Header.Get("Authorization")
+		w.Write([]byte(`{"gitVersion":"v1.30.0"}`))
+	}))
+	defer server.Close()
+
+	b, storage := getBackend(t)
+
+	// Neither kubernetes_ca_cert nor token_reviewer_jwt are configured, as
+	// when running in a pod, the local ones must be used.
+	cert, err := ioutil.TempFile("", "ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(cert.Name())
+	pem.Encode(cert, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cert.Close()
+
+	token, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(token.Name())
+	token.WriteString(testLocalJWT)
+	token.Close()
+
+	b.(*kubeAuthBackend).localCACertReader = newCachingFileReader(cert.Name(), caReloadPeriod, time.Now)
+	b.(*kubeAuthBackend).localSATokenReader = newCachingFileReader(token.Name(), jwtReloadPeriod, time.Now)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":        testDefaultPEMs,
+			"kubernetes_host": server.URL,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/clock-skew",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if want := "Bearer " + testLocalJWT; bearer != want {
+		t.Fatalf("expected the local JWT to be sent, got %q", bearer)
+	}
+}
+
+func TestConfig_ClockSkewNotConfigured(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/clock-skew",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || resp.Error().Error() != "backend is not configured" {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+}
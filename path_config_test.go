@@ -2,12 +2,24 @@ package kubeauth
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
 	"time"
 
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -48,6 +60,63 @@ func TestConfig_Read(t *testing.T) {
 		"disable_iss_validation": false,
 		"disable_local_ca_jwt":   false,
 		"enable_custom_metadata_from_annotations": false,
+		"require_tls_connection":                  false,
+		"allow_default_service_account":           true,
+		"not_before_leeway":                       int64(0),
+		"max_roles":                               0,
+		"kubernetes_tls_server_name":              "",
+		"pem_keys_dir":                            "",
+		"require_service_account_subject":         true,
+		"max_future_iat":                          int64(0),
+		"strict_role_fields":                      false,
+		"min_kubernetes_version":                  "",
+		"lowercase_namespace_name_matching":       false,
+		"required_claims":                         []string{},
+		"verbose_denials":                         false,
+		"token_review_for_projected_only":         false,
+		"common_policies":                         []string{},
+		"verification_precedence":                 verificationPrecedenceBothRequired,
+		"namespace_prefix_strip":                  "",
+		"max_metadata_bytes":                      0,
+		"max_metadata_overflow":                   metadataOverflowDefault,
+		"role_aliases":                            map[string]string{},
+		"propagate_audit_id":                      false,
+		"legacy_error_codes":                      false,
+		"warn_on_legacy_token":                    false,
+		"require_exp_claim":                       false,
+		"verify_x5c":                              false,
+		"strict_sa_read_namespace":                false,
+		"role_reconcile_interval":                 int64(0),
+		"require_kid":                             false,
+		"sa_not_found_metadata_mode":              saNotFoundMetadataDefault,
+		"jwt_field_name":                          "jwt",
+		"cluster_name":                            "",
+		"api_idle_conn_timeout":                   int64(0),
+		"api_disable_keepalives":                  false,
+		"echoable_claims":                         []string{},
+		"strict_projected_claims":                 false,
+		"warn_on_alias_collision":                 false,
+		"global_login_concurrency":                0,
+		"fair_concurrency":                        false,
+		"reject_empty_policy_roles":               false,
+		"token_review_api_version":                tokenReviewAPIVersionDefault,
+		"annotation_collision_mode":               annotationCollisionDefault,
+		"max_num_uses":                            0,
+		"require_https_host":                      false,
+		"bind_token_to_remote_addr":               false,
+		"default_token_review_audiences":          []string{},
+		"lowercase_annotation_values":             false,
+		"trust_token_review_for_expiry":           false,
+		"include_timing":                          false,
+		"max_token_audiences":                     defaultMaxTokenAudiences,
+		"role_claim":                              "",
+		"claim_selectable_roles":                  []string{},
+		"min_rsa_key_bits":                        defaultMinRSAKeyBits,
+		"validate_namespace_exists":               false,
+		"claim_parse_order":                       claimParseOrderDefault,
+		"enable_pod_annotation_metadata":          false,
+		"pod_annotation_prefix":                   allowedAnnotationPrefix,
+		"token_review_unreachable_mode":           tokenReviewUnreachableDefault,
 	}
 
 	req := &logical.Request{
@@ -74,9 +143,90 @@ func TestConfig_Read(t *testing.T) {
 		t.Fatalf("err:%s resp:%#v\n", err, resp)
 	}
 
+	export := resp.Data["export"]
+	delete(resp.Data, "export")
 	if !reflect.DeepEqual(resp.Data, data) {
 		t.Fatalf("Expected did not equal actual: expected %#v\n got %#v\n", data, resp.Data)
 	}
+	if !reflect.DeepEqual(export, data) {
+		t.Fatalf("Expected did not equal actual export: expected %#v\n got %#v\n", data, export)
+	}
+}
+
+func TestConfig_Export(t *testing.T) {
+	b, storage := getBackend(t)
+
+	cleanup := setupLocalFiles(t, b)
+	defer cleanup()
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":                []string{testRSACert},
+			"kubernetes_host":         "host",
+			"kubernetes_ca_cert":      testCACert,
+			"token_reviewer_jwt":      jwtData,
+			"sa_read_token":           "sa-read-token",
+			"issuer":                  "custom-issuer",
+			"require_tls_connection":  true,
+			"attestation_signing_key": testAttestationKeyPEM,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected, err := b.(*kubeAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	export := resp.Data["export"].(map[string]interface{})
+	if export["token_reviewer_jwt"] != redactedPlaceholder {
+		t.Fatalf("expected token_reviewer_jwt to be redacted, got %v", export["token_reviewer_jwt"])
+	}
+	if export["sa_read_token"] != redactedPlaceholder {
+		t.Fatalf("expected sa_read_token to be redacted, got %v", export["sa_read_token"])
+	}
+	if export["attestation_signing_key"] != redactedPlaceholder {
+		t.Fatalf("expected attestation_signing_key to be redacted, got %v", export["attestation_signing_key"])
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data:      export,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	actual, err := b.(*kubeAuthBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
 }
 
 func TestConfig(t *testing.T) {
@@ -167,11 +317,31 @@ func TestConfig(t *testing.T) {
 	}
 
 	expected := &kubeConfig{
-		PublicKeys:           []interface{}{},
-		PEMKeys:              []string{},
-		Host:                 "host",
-		CACert:               testCACert,
-		DisableISSValidation: true,
+		PublicKeys:                   []interface{}{},
+		PEMKeys:                      []string{},
+		Host:                         "host",
+		CACert:                       testCACert,
+		DisableISSValidation:         true,
+		AllowDefaultServiceAccount:   true,
+		RequireServiceAccountSubject: true,
+		RequiredClaims:               []string{},
+		EchoableClaims:               []string{},
+		DefaultTokenReviewAudiences:  []string{},
+		ClaimSelectableRoles:         []string{},
+		CommonPolicies:               []string{},
+		VerificationPrecedence:       verificationPrecedenceBothRequired,
+		MaxMetadataOverflow:          metadataOverflowDefault,
+		SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+		TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+		AnnotationCollisionMode:      annotationCollisionDefault,
+		MaxTokenAudiences:            defaultMaxTokenAudiences,
+		MinRSAKeyBits:                defaultMinRSAKeyBits,
+		ClaimParseOrder:              claimParseOrderDefault,
+		PodAnnotationPrefix:          allowedAnnotationPrefix,
+		TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+		JWTFieldName:                 "jwt",
+		RoleAliases:                  map[string]string{},
+		Version:                      currentConfigVersion,
 	}
 
 	conf, err := b.(*kubeAuthBackend).config(context.Background(), storage)
@@ -208,13 +378,33 @@ func TestConfig(t *testing.T) {
 	}
 
 	expected = &kubeConfig{
-		PublicKeys:           []interface{}{},
-		PEMKeys:              []string{},
-		Host:                 "host",
-		CACert:               testCACert,
-		TokenReviewerJWT:     jwtData,
-		DisableISSValidation: true,
-		DisableLocalCAJwt:    false,
+		PublicKeys:                   []interface{}{},
+		PEMKeys:                      []string{},
+		Host:                         "host",
+		CACert:                       testCACert,
+		TokenReviewerJWT:             jwtData,
+		DisableISSValidation:         true,
+		DisableLocalCAJwt:            false,
+		AllowDefaultServiceAccount:   true,
+		RequireServiceAccountSubject: true,
+		RequiredClaims:               []string{},
+		EchoableClaims:               []string{},
+		DefaultTokenReviewAudiences:  []string{},
+		ClaimSelectableRoles:         []string{},
+		CommonPolicies:               []string{},
+		VerificationPrecedence:       verificationPrecedenceBothRequired,
+		MaxMetadataOverflow:          metadataOverflowDefault,
+		SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+		TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+		AnnotationCollisionMode:      annotationCollisionDefault,
+		MaxTokenAudiences:            defaultMaxTokenAudiences,
+		MinRSAKeyBits:                defaultMinRSAKeyBits,
+		ClaimParseOrder:              claimParseOrderDefault,
+		PodAnnotationPrefix:          allowedAnnotationPrefix,
+		TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+		JWTFieldName:                 "jwt",
+		RoleAliases:                  map[string]string{},
+		Version:                      currentConfigVersion,
 	}
 
 	conf, err = b.(*kubeAuthBackend).config(context.Background(), storage)
@@ -251,12 +441,32 @@ func TestConfig(t *testing.T) {
 	}
 
 	expected = &kubeConfig{
-		PublicKeys:           []interface{}{cert},
-		PEMKeys:              []string{testRSACert},
-		Host:                 "host",
-		CACert:               testCACert,
-		DisableISSValidation: true,
-		DisableLocalCAJwt:    false,
+		PublicKeys:                   []interface{}{cert},
+		PEMKeys:                      []string{testRSACert},
+		Host:                         "host",
+		CACert:                       testCACert,
+		DisableISSValidation:         true,
+		DisableLocalCAJwt:            false,
+		AllowDefaultServiceAccount:   true,
+		RequireServiceAccountSubject: true,
+		RequiredClaims:               []string{},
+		EchoableClaims:               []string{},
+		DefaultTokenReviewAudiences:  []string{},
+		ClaimSelectableRoles:         []string{},
+		CommonPolicies:               []string{},
+		VerificationPrecedence:       verificationPrecedenceBothRequired,
+		MaxMetadataOverflow:          metadataOverflowDefault,
+		SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+		TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+		AnnotationCollisionMode:      annotationCollisionDefault,
+		MaxTokenAudiences:            defaultMaxTokenAudiences,
+		MinRSAKeyBits:                defaultMinRSAKeyBits,
+		ClaimParseOrder:              claimParseOrderDefault,
+		PodAnnotationPrefix:          allowedAnnotationPrefix,
+		TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+		JWTFieldName:                 "jwt",
+		RoleAliases:                  map[string]string{},
+		Version:                      currentConfigVersion,
 	}
 
 	conf, err = b.(*kubeAuthBackend).config(context.Background(), storage)
@@ -298,12 +508,32 @@ func TestConfig(t *testing.T) {
 	}
 
 	expected = &kubeConfig{
-		PublicKeys:           []interface{}{cert, cert2},
-		PEMKeys:              []string{testRSACert, testECCert},
-		Host:                 "host",
-		CACert:               testCACert,
-		DisableISSValidation: true,
-		DisableLocalCAJwt:    false,
+		PublicKeys:                   []interface{}{cert, cert2},
+		PEMKeys:                      []string{testRSACert, testECCert},
+		Host:                         "host",
+		CACert:                       testCACert,
+		DisableISSValidation:         true,
+		DisableLocalCAJwt:            false,
+		AllowDefaultServiceAccount:   true,
+		RequireServiceAccountSubject: true,
+		RequiredClaims:               []string{},
+		EchoableClaims:               []string{},
+		DefaultTokenReviewAudiences:  []string{},
+		ClaimSelectableRoles:         []string{},
+		CommonPolicies:               []string{},
+		VerificationPrecedence:       verificationPrecedenceBothRequired,
+		MaxMetadataOverflow:          metadataOverflowDefault,
+		SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+		TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+		AnnotationCollisionMode:      annotationCollisionDefault,
+		MaxTokenAudiences:            defaultMaxTokenAudiences,
+		MinRSAKeyBits:                defaultMinRSAKeyBits,
+		ClaimParseOrder:              claimParseOrderDefault,
+		PodAnnotationPrefix:          allowedAnnotationPrefix,
+		TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+		JWTFieldName:                 "jwt",
+		RoleAliases:                  map[string]string{},
+		Version:                      currentConfigVersion,
 	}
 
 	conf, err = b.(*kubeAuthBackend).config(context.Background(), storage)
@@ -340,12 +570,32 @@ func TestConfig(t *testing.T) {
 	}
 
 	expected = &kubeConfig{
-		PublicKeys:           []interface{}{},
-		PEMKeys:              []string{},
-		Host:                 "host",
-		CACert:               testCACert,
-		DisableISSValidation: true,
-		DisableLocalCAJwt:    false,
+		PublicKeys:                   []interface{}{},
+		PEMKeys:                      []string{},
+		Host:                         "host",
+		CACert:                       testCACert,
+		DisableISSValidation:         true,
+		DisableLocalCAJwt:            false,
+		AllowDefaultServiceAccount:   true,
+		RequireServiceAccountSubject: true,
+		RequiredClaims:               []string{},
+		EchoableClaims:               []string{},
+		DefaultTokenReviewAudiences:  []string{},
+		ClaimSelectableRoles:         []string{},
+		CommonPolicies:               []string{},
+		VerificationPrecedence:       verificationPrecedenceBothRequired,
+		MaxMetadataOverflow:          metadataOverflowDefault,
+		SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+		TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+		AnnotationCollisionMode:      annotationCollisionDefault,
+		MaxTokenAudiences:            defaultMaxTokenAudiences,
+		MinRSAKeyBits:                defaultMinRSAKeyBits,
+		ClaimParseOrder:              claimParseOrderDefault,
+		PodAnnotationPrefix:          allowedAnnotationPrefix,
+		TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+		JWTFieldName:                 "jwt",
+		RoleAliases:                  map[string]string{},
+		Version:                      currentConfigVersion,
 	}
 
 	conf, err = b.(*kubeAuthBackend).config(context.Background(), storage)
@@ -370,13 +620,33 @@ func TestConfig_LocalCaJWT(t *testing.T) {
 			},
 			setupInClusterFiles: true,
 			expected: &kubeConfig{
-				PublicKeys:           []interface{}{},
-				PEMKeys:              []string{},
-				Host:                 "host",
-				CACert:               testLocalCACert,
-				TokenReviewerJWT:     testLocalJWT,
-				DisableISSValidation: true,
-				DisableLocalCAJwt:    false,
+				PublicKeys:                   []interface{}{},
+				PEMKeys:                      []string{},
+				Host:                         "host",
+				CACert:                       testLocalCACert,
+				TokenReviewerJWT:             testLocalJWT,
+				DisableISSValidation:         true,
+				DisableLocalCAJwt:            false,
+				AllowDefaultServiceAccount:   true,
+				RequireServiceAccountSubject: true,
+				RequiredClaims:               []string{},
+				EchoableClaims:               []string{},
+				DefaultTokenReviewAudiences:  []string{},
+				ClaimSelectableRoles:         []string{},
+				CommonPolicies:               []string{},
+				VerificationPrecedence:       verificationPrecedenceBothRequired,
+				MaxMetadataOverflow:          metadataOverflowDefault,
+				SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:      annotationCollisionDefault,
+				MaxTokenAudiences:            defaultMaxTokenAudiences,
+				MinRSAKeyBits:                defaultMinRSAKeyBits,
+				ClaimParseOrder:              claimParseOrderDefault,
+				PodAnnotationPrefix:          allowedAnnotationPrefix,
+				TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+				JWTFieldName:                 "jwt",
+				RoleAliases:                  map[string]string{},
+				Version:                      currentConfigVersion,
 			},
 		},
 		"CA set, default to local JWT": {
@@ -386,13 +656,33 @@ func TestConfig_LocalCaJWT(t *testing.T) {
 			},
 			setupInClusterFiles: true,
 			expected: &kubeConfig{
-				PublicKeys:           []interface{}{},
-				PEMKeys:              []string{},
-				Host:                 "host",
-				CACert:               testCACert,
-				TokenReviewerJWT:     testLocalJWT,
-				DisableISSValidation: true,
-				DisableLocalCAJwt:    false,
+				PublicKeys:                   []interface{}{},
+				PEMKeys:                      []string{},
+				Host:                         "host",
+				CACert:                       testCACert,
+				TokenReviewerJWT:             testLocalJWT,
+				DisableISSValidation:         true,
+				DisableLocalCAJwt:            false,
+				AllowDefaultServiceAccount:   true,
+				RequireServiceAccountSubject: true,
+				RequiredClaims:               []string{},
+				EchoableClaims:               []string{},
+				DefaultTokenReviewAudiences:  []string{},
+				ClaimSelectableRoles:         []string{},
+				CommonPolicies:               []string{},
+				VerificationPrecedence:       verificationPrecedenceBothRequired,
+				MaxMetadataOverflow:          metadataOverflowDefault,
+				SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:      annotationCollisionDefault,
+				MaxTokenAudiences:            defaultMaxTokenAudiences,
+				MinRSAKeyBits:                defaultMinRSAKeyBits,
+				ClaimParseOrder:              claimParseOrderDefault,
+				PodAnnotationPrefix:          allowedAnnotationPrefix,
+				TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+				JWTFieldName:                 "jwt",
+				RoleAliases:                  map[string]string{},
+				Version:                      currentConfigVersion,
 			},
 		},
 		"JWT set, default to local CA": {
@@ -402,13 +692,33 @@ func TestConfig_LocalCaJWT(t *testing.T) {
 			},
 			setupInClusterFiles: true,
 			expected: &kubeConfig{
-				PublicKeys:           []interface{}{},
-				PEMKeys:              []string{},
-				Host:                 "host",
-				CACert:               testLocalCACert,
-				TokenReviewerJWT:     jwtData,
-				DisableISSValidation: true,
-				DisableLocalCAJwt:    false,
+				PublicKeys:                   []interface{}{},
+				PEMKeys:                      []string{},
+				Host:                         "host",
+				CACert:                       testLocalCACert,
+				TokenReviewerJWT:             jwtData,
+				DisableISSValidation:         true,
+				DisableLocalCAJwt:            false,
+				AllowDefaultServiceAccount:   true,
+				RequireServiceAccountSubject: true,
+				RequiredClaims:               []string{},
+				EchoableClaims:               []string{},
+				DefaultTokenReviewAudiences:  []string{},
+				ClaimSelectableRoles:         []string{},
+				CommonPolicies:               []string{},
+				VerificationPrecedence:       verificationPrecedenceBothRequired,
+				MaxMetadataOverflow:          metadataOverflowDefault,
+				SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:      annotationCollisionDefault,
+				MaxTokenAudiences:            defaultMaxTokenAudiences,
+				MinRSAKeyBits:                defaultMinRSAKeyBits,
+				ClaimParseOrder:              claimParseOrderDefault,
+				PodAnnotationPrefix:          allowedAnnotationPrefix,
+				TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+				JWTFieldName:                 "jwt",
+				RoleAliases:                  map[string]string{},
+				Version:                      currentConfigVersion,
 			},
 		},
 		"CA and disable local default": {
@@ -418,13 +728,33 @@ func TestConfig_LocalCaJWT(t *testing.T) {
 				"disable_local_ca_jwt": true,
 			},
 			expected: &kubeConfig{
-				PublicKeys:           []interface{}{},
-				PEMKeys:              []string{},
-				Host:                 "host",
-				CACert:               testCACert,
-				TokenReviewerJWT:     "",
-				DisableISSValidation: true,
-				DisableLocalCAJwt:    true,
+				PublicKeys:                   []interface{}{},
+				PEMKeys:                      []string{},
+				Host:                         "host",
+				CACert:                       testCACert,
+				TokenReviewerJWT:             "",
+				DisableISSValidation:         true,
+				DisableLocalCAJwt:            true,
+				AllowDefaultServiceAccount:   true,
+				RequireServiceAccountSubject: true,
+				RequiredClaims:               []string{},
+				EchoableClaims:               []string{},
+				DefaultTokenReviewAudiences:  []string{},
+				ClaimSelectableRoles:         []string{},
+				CommonPolicies:               []string{},
+				VerificationPrecedence:       verificationPrecedenceBothRequired,
+				MaxMetadataOverflow:          metadataOverflowDefault,
+				SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:      annotationCollisionDefault,
+				MaxTokenAudiences:            defaultMaxTokenAudiences,
+				MinRSAKeyBits:                defaultMinRSAKeyBits,
+				ClaimParseOrder:              claimParseOrderDefault,
+				PodAnnotationPrefix:          allowedAnnotationPrefix,
+				TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+				JWTFieldName:                 "jwt",
+				RoleAliases:                  map[string]string{},
+				Version:                      currentConfigVersion,
 			},
 		},
 	}
@@ -603,22 +933,638 @@ srxZG4EwDAYDVR0TBAUwAwEB/zAJBgcqhkjOPQQBA2gAMGUCMCR+CvAoNBhqSe2M
 GxqJpa7Onn15Hu8zTsdzeYBqUUXA6wtn+Pa7197CgUkfty9yc2eeQw==
 -----END CERTIFICATE-----`
 
+// testCACert is a self-signed CA valid until 2126, long enough for the login
+// tests not to be denied by the CA validity check.
 var testCACert string = `
 -----BEGIN CERTIFICATE-----
-MIIC5zCCAc+gAwIBAgIBATANBgkqhkiG9w0BAQsFADAVMRMwEQYDVQQDEwptaW5p
-a3ViZUNBMB4XDTE3MDgxMDIzMTQ1NVoXDTI3MDgwODIzMTQ1NVowFTETMBEGA1UE
-AxMKbWluaWt1YmVDQTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBAN8d
-w2p/KXRkm+vzOO0eT1vYBWP7fKsnng9/g5nnXAJlt9NxpOSolRcyItm/04R0E1jx
-jpgsdzkybc+QU5ZiszOYN833/D5hCNVAABVivpDd2P8wVKXN46cB99e24etUVBqG
-5aR0Ku3IBsJjCN9efhF+XRCA2gy/KaXMdKJhHfdtc8hCr7G9+2wO2G58FLmIfEyH
-owviOGt0BSnCtMpsA8ZgGQyfqgSd5u466aCv6oj0MyzsMnfS38niM53Rlv4IY6ol
-taYbWXtCNndQ2S687qE0qTCxhE95Bm2Nfkqct4R1798sJz83xNv8hALvxr/vPK/J
-2XkIm3oo3YKG4n/CHXcCAwEAAaNCMEAwDgYDVR0PAQH/BAQDAgKkMB0GA1UdJQQW
-MBQGCCsGAQUFBwMCBggrBgEFBQcDATAPBgNVHRMBAf8EBTADAQH/MA0GCSqGSIb3
-DQEBCwUAA4IBAQCSkrhE1PczqeqXfRaWayJUbXWPwKFbszO0MhGB1zwnPZq39qjY
-ySQiGvnjV3fP+N5CTQAwMNe79Xiw31fSoexgceCPJpraWrTOLdCv04SbGDBapMFM
-aezBu9jzZm0CNt60jHXWXuHHVPFX6u7ZR8W+RiBvsT8GZ5U6sNs3aN3M9Vym06BL
-aSphIw1v+hRlPfnrlJwUnQp158DRgkt/9ncTa/k88KoIoZAbulaiGB4zHxxkbura
-GSlgpZzhHSrBDLuXf65GHwwGxSExhgY5AA/n8rumGVvE8IYohS9yg/jOG0xP2WQH
-u/ABoYtOyseO+lgElA8R4PB9MtwgN6c/b0xH
+MIIDHTCCAgWgAwIBAgIUCFkBpwE7+0kbx+Tl6gZB3zfC8/8wDQYJKoZIhvcNAQEL
+BQAwFTETMBEGA1UEAwwKbWluaWt1YmVDQTAgFw0yNjEwMTQxODA2MjVaGA8yMTI2
+MDkyMDE4MDYyNVowFTETMBEGA1UEAwwKbWluaWt1YmVDQTCCASIwDQYJKoZIhvcN
+AQEBBQADggEPADCCAQoCggEBAOPahdIrcNYyMnVVadXwrkwDlcJYWyatHvcjybwe
+6xjRotRI6IYqbGaS8id/3GhKXhr+z8dMAd4O4Vpsx/Km7K6ZxphMheGmd8KwdV6Q
+Tobp/n1SAC0GiZ7JUU4peXP1nV6B3yEBiaKJm3NBUHUvUsJc5Lg0O/I76uKNu/tO
+8wuMB36zi6tSIRBSOet5OVc+yDpgTH508hW8OUhKFwdHYgQuDqpJ9p3Dlz4MiVWo
+LwaVS304kyE0mToQdHIzJGHuLKz+Fl2G+Tgn7VjNTOGClN3j0/FrKXdKygfiC8hQ
+vS0ZRUZp1zo5OM5gdUG5iWgn36iypNSr/YpvgOg74DDspB8CAwEAAaNjMGEwHQYD
+VR0OBBYEFH564ktFuah2smrROKsnr5gPkKjkMB8GA1UdIwQYMBaAFH564ktFuah2
+smrROKsnr5gPkKjkMA8GA1UdEwEB/wQFMAMBAf8wDgYDVR0PAQH/BAQDAgKkMA0G
+CSqGSIb3DQEBCwUAA4IBAQA+We60p9O4m+BLS36fKHTKYh79U+bfKjdiBRnbRGfm
+BRvHQ/6A0aIUqLGK6nslqjDNKSJsYFadKk1V2bqlUzcM7NK1qbr/BDXcH392ltaG
+mon7t3fFPlRO8u0RZwg4rQkEBI8zg2QiqbkE0QhUBsvyw+LXNfH84s2kQFW7q2UC
+TNnwIK+ZmH394YtG10Ep/8VWKfTma7YJ/PAjfSyZl7HVMXcHtaN5xGCPKUBryski
+rzismEFTP+X6Nb76VSkRqSD9JzycdZykAwlX+GfEaEw7kPFWFhdoSmBI1BxK5imE
+o2u2Z7ImaY5uvotXNdi8o8/2rrMx8cC1qh0wfHb8Oq9f
 -----END CERTIFICATE-----`
+
+func TestConfig_TLSServerName(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes.internal"},
+		DNSNames:              []string{"kubernetes.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{"authenticated":true,"user":{"username":"system:serviceaccount:default:vault-auth","uid":"` + testUID + `"}}}`))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	testCases := map[string]struct {
+		serverName string
+		wantErr    bool
+	}{
+		"dialed address": {
+			wantErr: true,
+		},
+		"configured server name": {
+			serverName: "kubernetes.internal",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := &kubeConfig{
+				Host:          server.URL,
+				CACert:        caCert,
+				TLSServerName: tc.serverName,
+			}
+
+			_, err := tokenReviewAPIFactory(config).Review(context.Background(), jwtData, nil)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("unexpected token review error: %v", err)
+			}
+
+			// The service account response does not matter, only that the TLS
+			// handshake behaves the same way.
+			_, err = serviceAccountAPIFactory(config).ReadAnnotations(context.Background(), testName, testNamespace)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("unexpected service account error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfig_APITransport(t *testing.T) {
+	b, storage := getBackend(t)
+
+	data := map[string]interface{}{
+		"pem_keys":               testDefaultPEMs,
+		"kubernetes_host":        "host",
+		"kubernetes_ca_cert":     testCACert,
+		"api_idle_conn_timeout":  "30s",
+		"api_disable_keepalives": true,
+	}
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data:      data,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	config, err := b.(*kubeAuthBackend).loadConfig(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reviewTransport := &http.Transport{}
+	config.configureTransport(reviewTransport)
+	transports := map[string]*http.Transport{
+		"token review":    reviewTransport,
+		"service account": serviceAccountAPIFactory(config).(*serviceAccountAPI).client.Transport.(*http.Transport),
+		"pod":             podAPIFactory(config).(*podAPI).client.Transport.(*http.Transport),
+		"namespace":       namespaceAPIFactory(config).(*namespaceAPI).client.Transport.(*http.Transport),
+	}
+	for name, transport := range transports {
+		if transport.IdleConnTimeout != 30*time.Second {
+			t.Fatalf("%s: expected an idle timeout of 30s, got %s", name, transport.IdleConnTimeout)
+		}
+		if !transport.DisableKeepAlives {
+			t.Fatalf("%s: expected keep-alives to be disabled", name)
+		}
+		if transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+			t.Fatalf("%s: expected the CA cert to be configured", name)
+		}
+	}
+
+	// The client default is kept when no timeout is configured.
+	transport := cleanhttp.DefaultPooledTransport()
+	idleConnTimeout := transport.IdleConnTimeout
+	(&kubeConfig{}).configureTransport(transport)
+	if transport.IdleConnTimeout != idleConnTimeout || transport.DisableKeepAlives {
+		t.Fatalf("expected the default transport settings, got %s and %t", transport.IdleConnTimeout, transport.DisableKeepAlives)
+	}
+}
+
+func TestConfig_MinKubernetesVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"20+","gitVersion":"v1.20.4-eks-6b7464"}`))
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		host        string
+		minVersion  string
+		wantVersion string
+		wantWarning string
+		wantErr     string
+	}{
+		"not configured": {
+			host: server.URL,
+		},
+		"older server": {
+			host:        server.URL,
+			minVersion:  "1.21",
+			wantVersion: "v1.20.4-eks-6b7464",
+			wantWarning: "kubernetes version v1.20.4-eks-6b7464 is older than min_kubernetes_version 1.21",
+		},
+		"newer server": {
+			host:        server.URL,
+			minVersion:  "v1.20.2",
+			wantVersion: "v1.20.4-eks-6b7464",
+		},
+		"same version": {
+			host:        server.URL,
+			minVersion:  "1.20.4",
+			wantVersion: "v1.20.4-eks-6b7464",
+		},
+		"version endpoint unavailable": {
+			host:        server.URL + "/missing",
+			minVersion:  "1.21",
+			wantWarning: "unable to determine kubernetes version: unexpected status 404 from kubernetes API",
+		},
+		"invalid version": {
+			host:       server.URL,
+			minVersion: "1.x",
+			wantErr:    `invalid kubernetes version "1.x"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b, storage := getBackend(t)
+
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"kubernetes_host":        tc.host,
+					"disable_local_ca_jwt":   true,
+					"kubernetes_ca_cert":     testCACert,
+					"min_kubernetes_version": tc.minVersion,
+				},
+			}
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if tc.wantErr != "" {
+				if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got err:%v resp:%#v", tc.wantErr, err, resp)
+				}
+				return
+			}
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			req = &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      configPath,
+				Storage:   storage,
+			}
+
+			resp, err = b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			version, ok := resp.Data["kubernetes_version"]
+			if tc.wantVersion == "" && ok {
+				t.Fatalf("expected no kubernetes_version, got %v", version)
+			}
+			if tc.wantVersion != "" && version != tc.wantVersion {
+				t.Fatalf("expected kubernetes_version %q, got %v", tc.wantVersion, version)
+			}
+
+			var warnings []string
+			if tc.wantWarning != "" {
+				warnings = []string{tc.wantWarning}
+			}
+			if !reflect.DeepEqual(resp.Warnings, warnings) {
+				t.Fatalf("expected warnings %#v, got %#v", warnings, resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestConfig_SAReadToken(t *testing.T) {
+	var bearer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"metadata":{"name":"vault-auth","namespace":"default"}}`))
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		saReadToken string
+		wantBearer  string
+	}{
+		"reviewer JWT": {
+			wantBearer: "Bearer " + jwtData,
+		},
+		"dedicated token": {
+			saReadToken: "sa-read-token",
+			wantBearer:  "Bearer sa-read-token",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bearer = ""
+			config := &kubeConfig{
+				Host:             server.URL,
+				TokenReviewerJWT: jwtData,
+				SAReadToken:      tc.saReadToken,
+			}
+
+			if _, err := serviceAccountAPIFactory(config).ReadAnnotations(context.Background(), testName, testNamespace); err != nil {
+				t.Fatal(err)
+			}
+			if bearer != tc.wantBearer {
+				t.Fatalf("expected bearer %q, got %q", tc.wantBearer, bearer)
+			}
+		})
+	}
+}
+
+func TestConfig_AnnotationCollisionMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"metadata":{"name":"vault-auth","namespace":"default","annotations":{
+			"auth-metadata.vault.hashicorp.com/service-role":"dashed",
+			"auth-metadata.vault.hashicorp.com/service_role":"underscored",
+			"auth-metadata.vault.hashicorp.com/team":"payments"
+		}}}`))
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		mode    string
+		want    map[string]string
+		wantErr string
+	}{
+		"error": {
+			mode:    annotationCollisionError,
+			wantErr: `annotations "auth-metadata.vault.hashicorp.com/service-role" and "auth-metadata.vault.hashicorp.com/service_role" both normalise to the metadata key "service_role"`,
+		},
+		"first_wins": {
+			mode: annotationCollisionFirstWins,
+			want: map[string]string{"service_role": "dashed", "team": "payments"},
+		},
+		"last_wins": {
+			mode: annotationCollisionLastWins,
+			want: map[string]string{"service_role": "underscored", "team": "payments"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := &kubeConfig{
+				Host:                    server.URL,
+				TokenReviewerJWT:        jwtData,
+				AnnotationCollisionMode: tc.mode,
+			}
+
+			annotations, err := serviceAccountAPIFactory(config).ReadAnnotations(context.Background(), testName, testNamespace)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, annotations.Annotations) {
+				t.Fatalf("expected annotations %#v, got %#v", tc.want, annotations.Annotations)
+			}
+		})
+	}
+}
+
+func TestConfig_LowercaseAnnotationValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"metadata":{"name":"vault-auth","namespace":"default","annotations":{
+			"auth-metadata.vault.hashicorp.com/Team":"Payments",
+			"unrelated.example.com/owner":"Alice"
+		}}}`))
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		lowercase bool
+		want      map[string]string
+	}{
+		"disabled": {
+			want: map[string]string{"Team": "Payments"},
+		},
+		"enabled": {
+			lowercase: true,
+			want:      map[string]string{"Team": "payments"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			config := &kubeConfig{
+				Host:                      server.URL,
+				TokenReviewerJWT:          jwtData,
+				LowercaseAnnotationValues: tc.lowercase,
+			}
+
+			annotations, err := serviceAccountAPIFactory(config).ReadAnnotations(context.Background(), testName, testNamespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, annotations.Annotations) {
+				t.Fatalf("expected annotations %#v, got %#v", tc.want, annotations.Annotations)
+			}
+		})
+	}
+}
+
+func TestConfig_MinRSAKeyBits(t *testing.T) {
+	b, storage := getBackend(t)
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&smallKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	testCases := map[string]struct {
+		pems    []string
+		minBits interface{}
+		wantErr string
+	}{
+		"2048-bit key": {
+			pems: []string{testECCert, testRSACert},
+		},
+		"1024-bit key": {
+			pems:    []string{testRSACert, smallKeyPEM},
+			wantErr: "pem_keys[1] is a 1024-bit RSA key, smaller than the 2048 bits required by min_rsa_key_bits",
+		},
+		"1024-bit key allowed": {
+			pems:    []string{testRSACert, smallKeyPEM},
+			minBits: 1024,
+		},
+		"negative": {
+			pems:    []string{testRSACert},
+			minBits: -1,
+			wantErr: "min_rsa_key_bits can not be negative",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := map[string]interface{}{
+				"pem_keys":           tc.pems,
+				"kubernetes_host":    "host",
+				"kubernetes_ca_cert": testCACert,
+			}
+			if tc.minBits != nil {
+				data["min_rsa_key_bits"] = tc.minBits
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr == "" {
+				if resp != nil && resp.IsError() {
+					t.Fatalf("unexpected error: %v", resp.Error())
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+			}
+		})
+	}
+}
+
+func TestConfig_RoleAliases(t *testing.T) {
+	b, storage := getBackend(t)
+
+	testCases := map[string]struct {
+		aliases     []string
+		wantAliases map[string]string
+		wantErr     string
+	}{
+		"lowercased": {
+			aliases:     []string{"Old=New"},
+			wantAliases: map[string]string{"old": "new"},
+		},
+		"self": {
+			aliases: []string{"old=Old"},
+			wantErr: `role alias "old" can not refer to itself`,
+		},
+		"chained": {
+			aliases: []string{"older=old", "old=new"},
+			wantErr: `role alias "older" can not refer to another alias "old"`,
+		},
+		"empty target": {
+			aliases: []string{"old= "},
+			wantErr: "role_aliases can not contain empty role names",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"kubernetes_host": "host",
+					"role_aliases":    tc.aliases,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr != "" {
+				if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+				}
+				return
+			}
+			if resp != nil && resp.IsError() {
+				t.Fatalf("unexpected error: %#v", resp)
+			}
+
+			conf, err := b.(*kubeAuthBackend).config(context.Background(), storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(conf.RoleAliases, tc.wantAliases) {
+				t.Fatalf("expected %#v, got %#v", tc.wantAliases, conf.RoleAliases)
+			}
+		})
+	}
+}
+
+func TestConfig_TokenReviewAPIVersion(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(version string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      configPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"kubernetes_host":          "host",
+				"kubernetes_ca_cert":       testCACert,
+				"token_review_api_version": version,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := write("v1beta1"); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error response: %#v", resp)
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      configPath,
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["token_review_api_version"] != tokenReviewAPIVersionV1beta1 {
+		t.Fatalf("expected token_review_api_version v1beta1, got %#v", resp.Data["token_review_api_version"])
+	}
+
+	if resp := write("v2"); resp == nil || !resp.IsError() || resp.Error().Error() != errInvalidTokenReviewAPIVersion.Error() {
+		t.Fatalf("expected invalid token_review_api_version error, got %#v", resp)
+	}
+}
+
+func TestConfig_RequireHTTPSHost(t *testing.T) {
+	b, storage := getBackend(t)
+
+	testCases := map[string]struct {
+		host             string
+		requireHTTPSHost bool
+		wantErr          string
+	}{
+		"https": {
+			host:             "https://kubernetes.default.svc",
+			requireHTTPSHost: true,
+		},
+		"http": {
+			host:             "http://kubernetes.default.svc",
+			requireHTTPSHost: true,
+			wantErr:          `kubernetes_host "http://kubernetes.default.svc" must use the https scheme when require_https_host is set`,
+		},
+		"no scheme": {
+			host:             "kubernetes.default.svc",
+			requireHTTPSHost: true,
+			wantErr:          `kubernetes_host "kubernetes.default.svc" must use the https scheme when require_https_host is set`,
+		},
+		"http without flag": {
+			host: "http://kubernetes.default.svc",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"kubernetes_host":    tc.host,
+					"kubernetes_ca_cert": testCACert,
+					"require_https_host": tc.requireHTTPSHost,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr == "" {
+				if resp != nil && resp.IsError() {
+					t.Fatalf("unexpected error response: %#v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+			}
+		})
+	}
+}
+
+func TestConfig_TokenReviewUnreachableFailOpenRequiresKeys(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host":               "host",
+			"kubernetes_ca_cert":            testCACert,
+			"token_review_unreachable_mode": tokenReviewUnreachableFailOpen,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `token_review_unreachable_mode "fail_open" requires pem_keys or pem_keys_dir`
+	if resp == nil || !resp.IsError() || resp.Error().Error() != want {
+		t.Fatalf("expected error %q, got %#v", want, resp)
+	}
+}
@@ -4,28 +4,81 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
 	"github.com/briankassouf/jose/crypto"
 	"github.com/briankassouf/jose/jws"
 	"github.com/briankassouf/jose/jwt"
 	"github.com/hashicorp/errwrap"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
+	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/cidrutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/mitchellh/mapstructure"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// authMethodStaticPEM and authMethodTokenReview are the auth_method
+	// metadata of logins authenticated by the signature of the JWT against the
+	// configured public keys and by the TokenReview API respectively.
+	authMethodStaticPEM   = "static_pem"
+	authMethodTokenReview = "token_review"
 )
 
 var (
 	// defaultJWTIssuer is used to verify the iss header on the JWT if the config doesn't specify an issuer.
 	defaultJWTIssuer = "kubernetes/serviceaccount"
 
+	// defaultServiceAccountName is the name of the service account Kubernetes
+	// creates in every namespace.
+	defaultServiceAccountName = "default"
+
+	// serviceAccountSubjectPrefix prefixes the sub claim of service account
+	// tokens, i.e. system:serviceaccount:(NAMESPACE):(SERVICEACCOUNT)
+	serviceAccountSubjectPrefix = "system:serviceaccount:"
+
 	// errMismatchedSigningMethod is used if the certificate doesn't match the
 	// JWT's expected signing method.
 	errMismatchedSigningMethod = errors.New("invalid signing method")
+
+	// reservedMetadataKeys are the metadata keys populated by the backend
+	// itself, they can not be overwritten by annotations or templates.
+	reservedMetadataKeys = []string{
+		"service_account_uid",
+		"service_account_name",
+		"service_account_namespace",
+		"service_account_secret_name",
+		"role",
+		"requested_role",
+		"token_period",
+		"suggested_response_wrapping_ttl",
+		"correlation_id",
+		"login_id",
+		"tenant",
+		"cluster_name",
+		"k8s_audit_id",
+		"token_review_uid",
+		"token_review_authenticated",
+		"auth_method",
+		"pod_node_name",
+	}
+
+	// maxCorrelationIDLength is the maximum length of the correlation_id
+	// recorded in the metadata, longer ids are truncated.
+	maxCorrelationIDLength = 128
 )
 
 // pathLogin returns the path configurations for login endpoints
@@ -35,16 +88,28 @@ func pathLogin(b *kubeAuthBackend) *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"role": {
 				Type:        framework.TypeString,
-				Description: `Name of the role against which the login is being attempted. This field is required`,
+				Description: `Name of the role against which the login is being attempted. This field is required unless role_claim is configured, in which case the role is read from the JWT.`,
 			},
 			"jwt": {
 				Type:        framework.TypeString,
 				Description: `A signed JWT for authenticating a service account. This field is required.`,
 			},
+			"correlation_id": {
+				Type:        framework.TypeString,
+				Description: `Optional identifier recorded in the token metadata and the logs to correlate the login with upstream requests. Characters other than letters, digits, '-', '_', '.' and ':' are removed and it is truncated to 128 characters.`,
+			},
+			"skip_metadata": {
+				Type:        framework.TypeBool,
+				Description: `If true, the service account annotations, namespace labels and pod node name are not read and only the built-in metadata is returned, even if enable_custom_metadata_from_annotations or the role's enable_namespace_metadata or enable_pod_metadata is set.`,
+			},
+			"return_claims": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Optional list of claims, as dot separated paths, whose validated values are returned in the claims field of the response data. Every claim must be listed in the echoable_claims config.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation:         b.pathLogin,
+			logical.UpdateOperation:         b.withLegacyErrorCodes(b.pathLogin),
 			logical.AliasLookaheadOperation: b.aliasLookahead,
 		},
 
@@ -55,35 +120,43 @@ func pathLogin(b *kubeAuthBackend) *framework.Path {
 
 // pathLogin is used to authenticate to this backend
 func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	roleName, resp := b.getFieldValueStr(data, "role")
-	if resp != nil {
-		return resp, nil
-	}
-
-	jwtStr, resp := b.getFieldValueStr(data, "jwt")
-	if resp != nil {
-		return resp, nil
-	}
+	correlationID := sanitizeCorrelationID(data.Get("correlation_id").(string))
 
 	b.l.RLock()
 	defer b.l.RUnlock()
 
+	roleName, resp, err := b.loginRoleName(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	role, err := b.role(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
+
+	var warnings []string
+	var alias string
 	if role == nil {
-		return logical.ErrorResponse(fmt.Sprintf("invalid role name %q", roleName)), nil
+		alias = roleName
+		role, roleName, err = b.roleByAlias(ctx, req.Storage, alias)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid role name %q", alias)), nil
+		}
+		warnings = append(warnings, fmt.Sprintf("role %q is a deprecated alias of role %q, log in with %q instead", alias, roleName, roleName))
 	}
 
 	// Check for a CIDR match.
 	if len(role.TokenBoundCIDRs) > 0 {
 		if req.Connection == nil {
 			b.Logger().Warn("token bound CIDRs found but no connection information available for validation")
-			return nil, logical.ErrPermissionDenied
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonCIDRNotAuthorized, logical.ErrPermissionDenied))
 		}
 		if !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, role.TokenBoundCIDRs) {
-			return nil, logical.ErrPermissionDenied
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonCIDRNotAuthorized, logical.ErrPermissionDenied))
 		}
 	}
 
@@ -92,21 +165,189 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
+	jwtStr, resp := b.loginJWT(req, data, config)
+	if resp != nil {
+		return resp, nil
+	}
+
+	// Unsigned tokens never verify, but are rejected upfront whatever the
+	// config so that attempts to use them can be alerted on.
+	if isUnsignedJWT(jwtStr) {
+		b.Logger().Warn("rejected unsigned token", "role", roleName, "correlation_id", correlationID)
+		metrics.IncrCounterWithLabels([]string{"kubernetes", "unsigned_token_rejected"}, 1, []metrics.Label{{Name: "role", Value: roleName}})
+		return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonUnsignedToken, errors.New("unsigned tokens are not accepted")))
+	}
+
+	returnClaims := data.Get("return_claims").([]string)
+	for _, claim := range returnClaims {
+		if !strutil.StrListContains(config.EchoableClaims, claim) {
+			return logical.ErrorResponse("claim %q is not in echoable_claims", claim), nil
+		}
+	}
+
+	if config.RequireTLSConnection && (req.Connection == nil || req.Connection.ConnState == nil) {
+		return roleLoginDenied(roleName, newLoginError(http.StatusBadRequest, reasonTLSRequired, errors.New("login must be performed over a TLS connection")))
+	}
+
+	if err := b.checkCACertValidity(config.CACert, time.Now()); err != nil {
+		return roleLoginDenied(roleName, err)
+	}
+
+	// Collect the audit ids of the kubernetes API requests made for this
+	// login so that it can be joined to the apiserver audit log.
+	var k8sAuditIDs *auditIDs
+	if config.PropagateAuditID {
+		ctx, k8sAuditIDs = withAuditIDs(ctx)
+	}
+
+	var timings *loginTimings
+	if config.IncludeTiming {
+		ctx, timings = withLoginTimings(ctx)
+	}
+
+	if config.TrustTokenReviewForExpiry {
+		b.Logger().Warn("reduced assurance login: the token's exp, nbf and iat claims are not checked, its validity is left to the TokenReview", "role", roleName, "correlation_id", correlationID)
+	}
+
 	serviceAccount, err := b.parseAndValidateJWT(ctx, jwtStr, role, config)
 	if err != nil {
-		return nil, err
+		return roleLoginDenied(roleName, err)
+	}
+
+	for _, claim := range config.RequiredClaims {
+		if _, ok := lookupClaim(serviceAccount.claims, claim); !ok {
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonRequiredClaimMissing, fmt.Errorf("missing required claim %s", claim)))
+		}
+	}
+
+	for claim, want := range role.RequireClaim {
+		value, ok := lookupClaim(serviceAccount.claims, claim)
+		if !ok {
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonRequiredClaimMissing, fmt.Errorf("missing required claim %s", claim)))
+		}
+		if !claimMatches(value, want) {
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonClaimNotAuthorized, fmt.Errorf("claim %s not authorized", claim)))
+		}
+	}
+
+	// Limit the concurrent logins reaching the kubernetes API for this role.
+	if role.MaxConcurrentLogins > 0 {
+		release, err := b.acquireLoginSlot(ctx, strings.ToLower(roleName), role.MaxConcurrentLogins)
+		if err != nil {
+			return roleLoginDenied(roleName, err)
+		}
+		defer release()
+	}
+
+	// Limit the concurrent logins reaching the kubernetes API across all
+	// roles, sharing the slots fairly between namespaces if configured.
+	if config.GlobalLoginConcurrency > 0 {
+		release, err := b.globalLoginSemaphore(config.GlobalLoginConcurrency, config.FairConcurrency).acquire(ctx, serviceAccount.namespace())
+		if err != nil {
+			return roleLoginDenied(roleName, err)
+		}
+		defer release()
 	}
 
 	aliasName, err := b.getAliasName(role, serviceAccount)
 	if err != nil {
-		return nil, err
+		return roleLoginDenied(roleName, err)
 	}
 
 	// look up the JWT token in the kubernetes API
-	err = serviceAccount.lookup(ctx, jwtStr, b.reviewFactory(config))
-	if err != nil {
-		b.Logger().Error(`login unauthorized due to: ` + err.Error())
-		return nil, logical.ErrPermissionDenied
+	var review *tokenReviewResult
+	if b.requiresTokenReview(config, serviceAccount) {
+		done := timeStage(ctx, timingTokenReview)
+		review, err = serviceAccount.lookup(ctx, jwtStr, tokenReviewAudiences(config, role, serviceAccount), b.reviewFactory(config))
+		done()
+		if err != nil && config.VerificationPrecedence == verificationPrecedenceSignatureWins && serviceAccount.signatureVerified && !config.TrustTokenReviewForExpiry {
+			b.Logger().Warn("TokenReview failed for a JWT with a valid signature, accepting it: "+err.Error(), "correlation_id", correlationID)
+			err = nil
+		}
+		if err != nil && errors.Is(err, errTokenReviewUnreachable) && config.TokenReviewUnreachableMode == tokenReviewUnreachableFailOpen && serviceAccount.signatureVerified && !config.TrustTokenReviewForExpiry {
+			warning := "TokenReview could not reach the kubernetes API, logged in with the signature verified against pem_keys only"
+			b.Logger().Warn(warning+": "+err.Error(), "role", roleName, "correlation_id", correlationID)
+			warnings = append(warnings, warning)
+			err = nil
+		}
+		if err != nil {
+			b.Logger().Error(`login unauthorized due to: `+err.Error(), "correlation_id", correlationID)
+			return roleLoginDenied(roleName, tokenReviewLoginError(err))
+		}
+	}
+
+	// Bind the token to the address it was first used from once it is
+	// authenticated, so that unauthenticated logins can't claim it.
+	if config.BindTokenToRemoteAddr && req.Connection != nil {
+		if err := b.checkTokenAddr(jwtStr, req.Connection.RemoteAddr, serviceAccount); err != nil {
+			return roleLoginDenied(roleName, err)
+		}
+	}
+
+	if len(role.OwnerReferences) > 0 {
+		if err := b.checkPodOwner(ctx, config, role, serviceAccount); err != nil {
+			return roleLoginDenied(roleName, err)
+		}
+	}
+
+	if config.ValidateNamespaceExists {
+		if err := b.checkNamespaceExists(ctx, config, serviceAccount); err != nil {
+			return roleLoginDenied(roleName, err)
+		}
+	}
+
+	// Callers which don't consume the metadata can opt out of the annotation
+	// lookup to save a round trip to the kubernetes API.
+	if config.EnableCustomMetadataFromAnnotations && !data.Get("skip_metadata").(bool) {
+		namespace := serviceAccount.namespace()
+		done := timeStage(ctx, timingAnnotationRead)
+		annotations, err := b.serviceAccountReaderFactory(config).ReadAnnotations(ctx, serviceAccount.name(), namespace)
+		done()
+		if err != nil && kubeerrors.IsNotFound(err) && config.SANotFoundMetadataMode == saNotFoundMetadataIgnore {
+			// The token can outlive its service account when its signature
+			// is verified locally, log in with the built-in metadata only.
+			b.Logger().Warn("service account not found, logging in without its annotations", "namespace", namespace, "name", serviceAccount.name(), "correlation_id", correlationID)
+			annotations, err = &serviceAccountAnnotations{Namespace: namespace}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read serviceaccount annotations: %v", err)
+		}
+
+		// The annotations end up in the token metadata, make sure they are
+		// those of the service account in the namespace which was validated.
+		if config.StrictSAReadNamespace && serviceAccount.normalise(annotations.Namespace) != namespace {
+			b.Logger().Error("service account annotations were read from an unexpected namespace", "namespace", namespace, "read_namespace", annotations.Namespace, "correlation_id", correlationID)
+			return roleLoginDenied(roleName, newLoginError(http.StatusForbidden, reasonSANamespaceMismatch, errors.New("service account was read from a namespace other than the token's")))
+		}
+
+		serviceAccount.Annotations = annotations.Annotations
+	}
+
+	var namespaceLabels map[string]string
+	if role.EnableNamespaceMetadata && len(role.NamespaceMetadataLabels) > 0 && !data.Get("skip_metadata").(bool) {
+		labels, err := b.namespaceReaderFactory(config).NamespaceLabels(ctx, serviceAccount.namespace())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace labels: %v", err)
+		}
+		namespaceLabels = namespaceLabelMetadata(role.NamespaceMetadataLabels, labels)
+	}
+
+	var nodeName string
+	if role.EnablePodMetadata && !data.Get("skip_metadata").(bool) {
+		nodeName, err = b.podNodeName(ctx, config, serviceAccount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var podAnnotations map[string]string
+	if config.EnablePodAnnotationMetadata && !data.Get("skip_metadata").(bool) {
+		done := timeStage(ctx, timingAnnotationRead)
+		podAnnotations, err = b.podAnnotations(ctx, config, serviceAccount)
+		done()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	uid, err := serviceAccount.uid()
@@ -114,6 +355,13 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
+	// loginID identifies this login in the token metadata, it is kept off the
+	// alias as the entity would otherwise be updated on every login.
+	loginID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
 	auth := &logical.Auth{
 		Alias: &logical.Alias{
 			Name: aliasName,
@@ -133,13 +381,102 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 			"service_account_namespace":   serviceAccount.namespace(),
 			"service_account_secret_name": serviceAccount.SecretName,
 			"role":                        roleName,
+			"login_id":                    loginID,
 		},
 		DisplayName: fmt.Sprintf("%s-%s", serviceAccount.namespace(), serviceAccount.name()),
 	}
 
-	if serviceAccount.Annotations != nil {
-		for key, value := range serviceAccount.Annotations {
+	if correlationID != "" {
+		auth.Metadata["correlation_id"] = correlationID
+	}
+
+	// The role metadata always holds the role which authorised the login,
+	// record the alias it was requested with for auditing.
+	if alias != "" {
+		auth.Metadata["requested_role"] = alias
+		b.Logger().Info("login authorised by role resolved from alias", "requested_role", alias, "role", roleName, "correlation_id", correlationID)
+	}
+
+	if k8sAuditIDs != nil {
+		if ids := k8sAuditIDs.String(); ids != "" {
+			auth.Metadata["k8s_audit_id"] = ids
+		}
+	}
+
+	// Record the outcome of the TokenReview, unless the login was accepted
+	// without one.
+	if review != nil {
+		auth.Metadata["token_review_uid"] = review.UID
+		auth.Metadata["token_review_authenticated"] = strconv.FormatBool(review.Authenticated)
+	}
+	auth.Metadata["auth_method"] = loginAuthMethod(review, serviceAccount)
+
+	// The node changes whenever the pod is rescheduled, keep it off the alias.
+	if nodeName != "" {
+		auth.Metadata["pod_node_name"] = nodeName
+	}
+
+	if serviceAccount.tenant != "" {
+		auth.Alias.Metadata["tenant"] = serviceAccount.tenant
+		auth.Metadata["tenant"] = serviceAccount.tenant
+	}
+
+	if config.ClusterName != "" {
+		auth.Alias.Metadata["cluster_name"] = config.ClusterName
+		auth.Metadata["cluster_name"] = config.ClusterName
+	}
+
+	// Expose the period so that clients can tune their renewal cadence.
+	if role.TokenPeriod > 0 {
+		auth.Metadata["token_period"] = strconv.FormatInt(int64(role.TokenPeriod.Seconds()), 10)
+	}
+
+	// Sensitive roles advise their clients to response-wrap the token.
+	if role.SuggestResponseWrappingTTL > 0 {
+		auth.Metadata["suggested_response_wrapping_ttl"] = strconv.FormatInt(int64(role.SuggestResponseWrappingTTL.Seconds()), 10)
+		warnings = append(warnings, fmt.Sprintf("role suggests response-wrapping the token with a TTL of %s, e.g. by setting X-Vault-Wrap-TTL", role.SuggestResponseWrappingTTL))
+	}
+
+	for key, value := range namespaceLabels {
+		auth.Alias.Metadata[key] = value
+		auth.Metadata[key] = value
+	}
+
+	if len(role.MetadataTemplates) > 0 {
+		templated, err := renderMetadataTemplates(role.MetadataTemplates, serviceAccount)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range templated {
+			auth.Alias.Metadata[key] = value
+			auth.Metadata[key] = value
+		}
+	}
+
+	// addAnnotations adds the annotations of kind to the token metadata, and
+	// to the alias metadata if alias is set. Keys already in the metadata are
+	// kept, and max_metadata_bytes caps the annotations of all kinds.
+	size := 0
+	addAnnotations := func(kind string, annotations map[string]string, alias bool) error {
+		// Sort the keys so that the annotations kept under max_metadata_bytes
+		// don't change between logins.
+		keys := make([]string, 0, len(annotations))
+		for key := range annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		dropped := 0
+		for _, key := range keys {
+			value := annotations[key]
+
 			// Ensure it's not possible to overwrite service_account_* information
+			if isReservedMetadataKey(key) {
+				continue
+			}
+			if len(role.AllowedAnnotationKeys) > 0 && !strutil.StrListContains(role.AllowedAnnotationKeys, key) {
+				continue
+			}
 			if _, exists := auth.Alias.Metadata[key]; exists {
 				continue
 			}
@@ -147,18 +484,126 @@ func (b *kubeAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 				continue
 			}
 
-			auth.Alias.Metadata[key] = value
+			if config.MaxMetadataBytes > 0 && size+len(key)+len(value) > config.MaxMetadataBytes {
+				if config.MaxMetadataOverflow == metadataOverflowFail {
+					return newLoginError(http.StatusForbidden, reasonMetadataTooLarge, fmt.Errorf("%s annotation metadata exceeds %d bytes", kind, config.MaxMetadataBytes))
+				}
+				dropped++
+				continue
+			}
+			size += len(key) + len(value)
+
+			if alias {
+				auth.Alias.Metadata[key] = value
+			}
 			auth.Metadata[key] = value
 		}
+
+		if dropped > 0 {
+			warning := fmt.Sprintf("dropped %d %s annotations exceeding max_metadata_bytes (%d)", dropped, kind, config.MaxMetadataBytes)
+			b.Logger().Warn(warning, "role", roleName, "correlation_id", correlationID)
+			warnings = append(warnings, warning)
+		}
+		return nil
+	}
+
+	// The service account annotations are added first so that they take
+	// precedence, the pod annotations are set by whoever creates the pod. Pods
+	// are replaced on every rollout, keep their annotations off the alias.
+	if err := addAnnotations("service account", serviceAccount.Annotations, true); err != nil {
+		return roleLoginDenied(roleName, err)
+	}
+	if err := addAnnotations("pod", podAnnotations, false); err != nil {
+		return roleLoginDenied(roleName, err)
+	}
+
+	// Keep only the selected keys on the alias, the token keeps all of them.
+	if len(role.AliasMetadataKeys) > 0 {
+		aliasMetadata := make(map[string]string, len(role.AliasMetadataKeys))
+		for _, key := range role.AliasMetadataKeys {
+			if value, ok := auth.Metadata[key]; ok {
+				aliasMetadata[key] = value
+			}
+		}
+		auth.Alias.Metadata = aliasMetadata
 	}
 
 	role.PopulateTokenAuth(auth)
 
+	// The common policies are managed centrally and added to every role's.
+	if len(config.CommonPolicies) > 0 {
+		auth.Policies = strutil.RemoveDuplicatesStable(append(auth.Policies, config.CommonPolicies...), false)
+	}
+
+	// Legacy tokens have no kubernetes.io claims, count their logins to track
+	// the migration to projected tokens.
+	if config.WarnOnLegacyToken && serviceAccount.Kubernetes == nil {
+		metrics.IncrCounterWithLabels([]string{"kubernetes", "legacy_token_login"}, 1, []metrics.Label{{Name: "role", Value: roleName}})
+		warnings = append(warnings, "logged in with a legacy service account token, which is deprecated; use a projected service account token instead")
+	}
+
+	// Only the claims of the validated JWT are echoed, never its header or
+	// signature.
+	var respData map[string]interface{}
+	if len(returnClaims) > 0 {
+		claims := make(map[string]interface{}, len(returnClaims))
+		for _, claim := range returnClaims {
+			if value, ok := lookupClaim(serviceAccount.claims, claim); ok {
+				claims[claim] = value
+			} else {
+				warnings = append(warnings, fmt.Sprintf("claim %q requested in return_claims is missing from the token", claim))
+			}
+		}
+		respData = map[string]interface{}{"claims": claims}
+	}
+
+	if config.AttestationSigningKey != "" {
+		attestation, err := signAttestation(config, roleName, serviceAccount, uid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if respData == nil {
+			respData = make(map[string]interface{}, 1)
+		}
+		respData["attestation"] = attestation
+	}
+
+	if timings != nil {
+		if respData == nil {
+			respData = make(map[string]interface{}, 1)
+		}
+		respData["timings"] = timings.Milliseconds()
+	}
+
+	b.Logger().Debug("login succeeded", "role", roleName, "alias", aliasName, "correlation_id", correlationID, "login_id", loginID)
+
 	return &logical.Response{
-		Auth: auth,
+		Auth:     auth,
+		Data:     respData,
+		Warnings: warnings,
 	}, nil
 }
 
+// sanitizeCorrelationID strips the characters not allowed in a correlation id
+// and truncates it to maxCorrelationIDLength.
+func sanitizeCorrelationID(id string) string {
+	id = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.', r == ':':
+			return r
+		default:
+			return -1
+		}
+	}, id)
+
+	if len(id) > maxCorrelationIDLength {
+		id = id[:maxCorrelationIDLength]
+	}
+	return id
+}
+
 func (b *kubeAuthBackend) getFieldValueStr(data *framework.FieldData, param string) (string, *logical.Response) {
 	val := data.Get(param).(string)
 	if len(val) == 0 {
@@ -167,8 +612,74 @@ func (b *kubeAuthBackend) getFieldValueStr(data *framework.FieldData, param stri
 	return val, nil
 }
 
+// loginRoleName returns the role of the login. Logins omitting it select the
+// role in the role_claim of their JWT, if configured, provided it is one of the
+// claim_selectable_roles. The JWT is not verified yet, it is validated against
+// the selected role like any other login. It must be called with l held.
+func (b *kubeAuthBackend) loginRoleName(ctx context.Context, req *logical.Request, data *framework.FieldData) (string, *logical.Response, error) {
+	if roleName := data.Get("role").(string); roleName != "" {
+		return roleName, nil, nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return "", nil, err
+	}
+	if config == nil || config.RoleClaim == "" {
+		return "", logical.ErrorResponse("missing role"), nil
+	}
+
+	jwtStr, resp := b.loginJWT(req, data, config)
+	if resp != nil {
+		return "", resp, nil
+	}
+	parsedJWT, err := jws.ParseJWT([]byte(jwtStr))
+	if err != nil {
+		return "", nil, newLoginError(http.StatusBadRequest, reasonJWTMalformed, err)
+	}
+	value, _ := lookupClaim(parsedJWT.Claims(), config.RoleClaim)
+	roleName, _ := value.(string)
+	if roleName == "" {
+		return "", logical.ErrorResponse("missing role and the token has no %s claim", config.RoleClaim), nil
+	}
+	if !strutil.StrListContains(config.ClaimSelectableRoles, strings.ToLower(roleName)) {
+		return "", nil, newLoginError(http.StatusForbidden, reasonRoleClaimNotAllowed, fmt.Errorf("role %q from claim %s is not in claim_selectable_roles", roleName, config.RoleClaim))
+	}
+	return roleName, nil, nil
+}
+
+// loginJWT returns the JWT submitted under the configured jwt_field_name.
+// Fields other than jwt are not part of the login schema, they are read from
+// the raw request data.
+func (b *kubeAuthBackend) loginJWT(req *logical.Request, data *framework.FieldData, config *kubeConfig) (string, *logical.Response) {
+	name := config.JWTFieldName
+	if name == "" || name == "jwt" {
+		return b.getFieldValueStr(data, "jwt")
+	}
+	jwtStr, _ := req.Data[name].(string)
+	if jwtStr == "" {
+		return "", logical.ErrorResponse("missing %s", name)
+	}
+	return jwtStr, nil
+}
+
+// getAliasName returns the alias name from the first of the role's alias name
+// sources which resolves. The error of the last source is returned if none
+// does.
 func (b *kubeAuthBackend) getAliasName(role *roleStorageEntry, serviceAccount *serviceAccount) (string, error) {
-	switch role.AliasNameSource {
+	var err error
+	for _, source := range splitAliasNameSources(role.AliasNameSource) {
+		var name string
+		name, err = aliasNameFromSource(source, role, serviceAccount)
+		if err == nil {
+			return name, nil
+		}
+	}
+	return "", err
+}
+
+func aliasNameFromSource(source string, role *roleStorageEntry, serviceAccount *serviceAccount) (string, error) {
+	switch source {
 	case aliasNameSourceSAUid, aliasNameSourceUnset:
 		uid, err := serviceAccount.uid()
 		if err != nil {
@@ -176,33 +687,213 @@ func (b *kubeAuthBackend) getAliasName(role *roleStorageEntry, serviceAccount *s
 		}
 		return uid, nil
 	case aliasNameSourceSAName:
-		return fmt.Sprintf("%s/%s", serviceAccount.Namespace, serviceAccount.Name), nil
+		namespace, name := serviceAccount.namespace(), serviceAccount.name()
+		if namespace == "" || name == "" {
+			return "", errors.New("could not parse service account name and namespace from claims")
+		}
+		return fmt.Sprintf("%s/%s", namespace, name), nil
+	case aliasNameSourceClaim:
+		value, ok := lookupClaim(serviceAccount.claims, role.AliasNameClaim)
+		if !ok {
+			return "", newLoginError(http.StatusBadRequest, reasonAliasClaimMissing, fmt.Errorf("claim %q used for the alias name is missing from the token", role.AliasNameClaim))
+		}
+		name, ok := value.(string)
+		if !ok || name == "" {
+			return "", newLoginError(http.StatusBadRequest, reasonAliasClaimMissing, fmt.Errorf("claim %q used for the alias name is not a non-empty string", role.AliasNameClaim))
+		}
+		return name, nil
 	default:
-		return "", fmt.Errorf("unknown alias_name_source %q", role.AliasNameSource)
+		return "", fmt.Errorf("unknown alias_name_source %q", source)
 	}
 }
 
-// aliasLookahead returns the alias object with the SA UID from the JWT
-// Claims.
-// Only JWTs matching the specified role's configuration will be accepted as valid.
-func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	roleName, resp := b.getFieldValueStr(data, "role")
-	if resp != nil {
-		return resp, nil
+// tokenReviewErrors are the TokenReview failures reported with a reason code
+// of their own, any other failure is a generic TOKEN_REVIEW_FAILED denial.
+var tokenReviewErrors = []struct {
+	err    error
+	reason string
+}{
+	{errTokenReviewAudienceMismatch, reasonTokenReviewAudienceMismatch},
+	{errTokenReviewTokenExpired, reasonTokenReviewTokenExpired},
+	{errTokenReviewNotFound, reasonTokenReviewNotFound},
+	{errTokenReviewerUnauthorized, reasonTokenReviewerUnauthorized},
+	{errTokenReviewUnreachable, reasonTokenReviewUnreachable},
+}
+
+// tokenReviewLoginError returns the login error for the failed TokenReview.
+// The details of err are only logged, the denial carries the matching
+// sentinel error.
+func tokenReviewLoginError(err error) error {
+	for _, e := range tokenReviewErrors {
+		if errors.Is(err, e.err) {
+			return newLoginError(http.StatusForbidden, e.reason, e.err)
+		}
 	}
+	return newLoginError(http.StatusForbidden, reasonTokenReviewFailed, logical.ErrPermissionDenied)
+}
 
-	jwtStr, resp := b.getFieldValueStr(data, "jwt")
-	if resp != nil {
-		return resp, nil
+// loginAuthMethod returns the verification path which authenticated the
+// token: the TokenReview if one accepted it, otherwise the signature check
+// against the configured public keys.
+func loginAuthMethod(review *tokenReviewResult, sa *serviceAccount) string {
+	if review == nil && sa.signatureVerified {
+		return authMethodStaticPEM
+	}
+	return authMethodTokenReview
+}
+
+// isUnsignedJWT reports whether the JWT has no signature or declares the none
+// algorithm in its header. The header is decoded by hand as the jose library
+// refuses to parse tokens with an unknown algorithm.
+func isUnsignedJWT(jwtStr string) bool {
+	parts := strings.Split(jwtStr, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	if parts[2] == "" {
+		return true
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return false
+	}
+	return strings.EqualFold(header.Alg, "none")
+}
+
+// tokenReviewAudiences returns the audiences the token of sa is reviewed for.
+// With default_token_review_audiences configured the role's audience overrides
+// the defaults, otherwise the audiences the token claims are reviewed.
+func tokenReviewAudiences(config *kubeConfig, role *roleStorageEntry, sa *serviceAccount) []string {
+	if len(config.DefaultTokenReviewAudiences) == 0 {
+		return sa.Audience
+	}
+	if role.Audience != "" {
+		return []string{role.Audience}
+	}
+	return config.DefaultTokenReviewAudiences
+}
+
+// requiresTokenReview reports whether the token of sa must be verified with
+// the TokenReview API. Legacy tokens can skip it when their signature has
+// been verified with the configured public keys, projected tokens are
+// always reviewed as their binding to a pod can only be checked by the API.
+// All tokens are reviewed when the TokenReview alone decides their validity.
+func (b *kubeAuthBackend) requiresTokenReview(config *kubeConfig, sa *serviceAccount) bool {
+	if config.TrustTokenReviewForExpiry || !config.TokenReviewForProjectedOnly || !sa.signatureVerified {
+		return true
+	}
+	return sa.Kubernetes != nil
+}
+
+// bindingDenial returns the error for a login denied because actual matches
+// none of the role's bound patterns. The patterns and the actual value are only
+// included when the config enables verbose denials.
+func bindingDenial(config *kubeConfig, msg, actual, field string, bound []string) error {
+	if !config.VerboseDenials {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %q does not match %s %q", msg, actual, field, bound)
+}
+
+// compileNamespacePrefixStrip compiles the namespace_prefix_strip pattern,
+// which must define the "tenant" and "namespace" named groups.
+func compileNamespacePrefixStrip(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace_prefix_strip: %v", err)
 	}
+	for _, group := range []string{"tenant", "namespace"} {
+		if re.SubexpIndex(group) < 0 {
+			return nil, fmt.Errorf("namespace_prefix_strip must define the %q named group", group)
+		}
+	}
+	return re, nil
+}
+
+// splitNamespace splits namespace into its tenant and the remaining namespace
+// with the compiled namespace_prefix_strip pattern. A namespace the pattern
+// does not match has no tenant and is returned unchanged.
+func splitNamespace(re *regexp.Regexp, namespace string) (string, string) {
+	match := re.FindStringSubmatch(namespace)
+	if match == nil {
+		return "", namespace
+	}
+	return match[re.SubexpIndex("tenant")], match[re.SubexpIndex("namespace")]
+}
+
+// lookupClaim resolves the dot separated path in claims. As claim names may
+// themselves contain dots, e.g. "kubernetes.io", the longest matching name is
+// tried first at each level.
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	for i := len(parts); i > 0; i-- {
+		value, ok := claims[strings.Join(parts[:i], ".")]
+		if !ok {
+			continue
+		}
+		if i == len(parts) {
+			return value, true
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			if value, ok := lookupClaim(nested, strings.Join(parts[i:], ".")); ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
 
+// claimMatches reports whether the claim value matches the glob pattern. Lists
+// match if any of their values does, other values are matched as strings.
+func claimMatches(value interface{}, pattern string) bool {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if claimMatches(item, pattern) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		return false
+	case nil:
+		return false
+	default:
+		return strutil.GlobbedStringsMatch(pattern, fmt.Sprint(v))
+	}
+}
+
+// aliasLookahead returns the alias object with the SA UID from the JWT
+// Claims.
+// Only JWTs matching the specified role's configuration will be accepted as valid.
+// The alias is resolved from the JWT alone: no TokenReview or other kubernetes
+// API call is made, so that the entity resolution can neither fail on a
+// transient apiserver error nor have side effects on it.
+func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	b.l.RLock()
 	defer b.l.RUnlock()
 
+	roleName, resp, err := b.loginRoleName(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	role, err := b.role(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
+	if role == nil {
+		role, _, err = b.roleByAlias(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if role == nil {
 		return logical.ErrorResponse(fmt.Sprintf("invalid role name %q", roleName)), nil
 	}
@@ -212,16 +903,21 @@ func (b *kubeAuthBackend) aliasLookahead(ctx context.Context, req *logical.Reque
 		return nil, err
 	}
 
+	jwtStr, resp := b.loginJWT(req, data, config)
+	if resp != nil {
+		return resp, nil
+	}
+
 	// validation of the JWT against the provided role ensures alias look ahead requests
 	// are authentic.
 	sa, err := b.parseAndValidateJWT(ctx, jwtStr, role, config)
 	if err != nil {
-		return nil, err
+		return roleLoginDenied(roleName, err)
 	}
 
 	aliasName, err := b.getAliasName(role, sa)
 	if err != nil {
-		return nil, err
+		return roleLoginDenied(roleName, err)
 	}
 
 	return &logical.Response{
@@ -238,30 +934,81 @@ func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string
 	// Parse into JWT
 	parsedJWT, err := jws.ParseJWT([]byte(jwtStr))
 	if err != nil {
-		return nil, err
+		return nil, newLoginError(http.StatusBadRequest, reasonJWTMalformed, err)
 	}
 
-	sa := &serviceAccount{}
-
+	var sa *serviceAccount
 	validator := &jwt.Validator{
 		Fn: func(c jwt.Claims) error {
 			// Decode claims into a service account object
-			err := mapstructure.Decode(c, sa)
+			sa, err = parseClaims(c, config.ClaimParseOrder)
 			if err != nil {
 				return err
 			}
+			sa.lowercase = config.LowercaseNamespaceNameMatching
+
+			// verify the token was issued for a service account
+			if config.RequireServiceAccountSubject {
+				if sub, _ := c.Get("sub").(string); !strings.HasPrefix(sub, serviceAccountSubjectPrefix) {
+					return newLoginError(http.StatusForbidden, reasonNotServiceAccountToken, errors.New("not a service account token"))
+				}
+			}
+
+			// verify the projected claims identify the service account
+			if config.StrictProjectedClaims && sa.Kubernetes != nil {
+				if err := sa.Kubernetes.validate(); err != nil {
+					return newLoginError(http.StatusBadRequest, reasonProjectedClaimsMalformed, err)
+				}
+			}
+
+			// verify the token expires, legacy tokens are valid forever
+			if config.RequireExpClaim {
+				if _, ok := c.Expiration(); !ok {
+					return newLoginError(http.StatusForbidden, reasonTokenExpiryMissing, errors.New("token has no expiry; refusing"))
+				}
+			}
 
-			// verify the namespace is allowed
-			if len(role.ServiceAccountNamespaces) > 1 || role.ServiceAccountNamespaces[0] != "*" {
-				if !strutil.StrListContainsGlob(role.ServiceAccountNamespaces, sa.namespace()) {
-					return errors.New("namespace not authorized")
+			// verify the token wasn't issued too far in the future, this is
+			// independent of the leeway applied to the nbf claim.
+			if config.MaxFutureIAT > 0 && !config.TrustTokenReviewForExpiry {
+				if iat, ok := c.IssuedAt(); ok && iat.After(time.Now().Add(config.MaxFutureIAT)) {
+					return newLoginError(http.StatusForbidden, reasonTokenIssuedInFuture, errors.New("token issued too far in the future"))
 				}
 			}
 
+			// verify the default service account is allowed to log in
+			if !config.AllowDefaultServiceAccount && sa.name() == defaultServiceAccountName {
+				return newLoginError(http.StatusForbidden, reasonDefaultSANotPermitted, errors.New("default service account not permitted"))
+			}
+
+			// sensitive roles must enumerate what they are bound to
+			if role.RequireExplicitBindings && role.hasWildcardBinding() {
+				return newLoginError(http.StatusForbidden, reasonWildcardBinding, errors.New(`role requires explicit bindings but is bound to "*"`))
+			}
+
+			// verify the namespace is allowed, on multi-tenant clusters only the
+			// part of the namespace following the tenant is matched.
+			namespace := sa.namespace()
+			if config.NamespacePrefixStrip != "" {
+				sa.tenant, namespace = splitNamespace(config.NamespacePrefixStripRegexp, namespace)
+			}
+			if !role.admitsNamespace(namespace) {
+				return newLoginError(http.StatusForbidden, reasonNamespaceNotAuthorized, bindingDenial(config, "namespace not authorized", namespace, "bound_service_account_namespaces", role.ServiceAccountNamespaces))
+			}
+
 			// verify the service account name is allowed
-			if len(role.ServiceAccountNames) > 1 || role.ServiceAccountNames[0] != "*" {
-				if !strutil.StrListContainsGlob(role.ServiceAccountNames, sa.name()) {
-					return errors.New("service account name not authorized")
+			if !role.admitsServiceAccountName(sa.name()) {
+				return newLoginError(http.StatusForbidden, reasonSANameNotAuthorized, bindingDenial(config, "service account name not authorized", sa.name(), "bound_service_account_names", role.ServiceAccountNames))
+			}
+
+			// verify the node the token is bound to is allowed
+			if len(role.NodeNames) > 0 {
+				node := sa.nodeName()
+				if node == "" {
+					return newLoginError(http.StatusForbidden, reasonNodeClaimMissing, errors.New("token has no node claim but the role requires bound_node_names"))
+				}
+				if !strutil.StrListContainsGlob(role.NodeNames, node) {
+					return newLoginError(http.StatusForbidden, reasonNodeNameNotAuthorized, errors.New("node name not authorized"))
 				}
 			}
 
@@ -279,22 +1026,44 @@ func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string
 		}
 	}
 
+	// reject tokens with an unusual number of audiences before matching them
+	if config.MaxTokenAudiences > 0 {
+		if audiences, _ := parsedJWT.Claims().Audience(); len(audiences) > config.MaxTokenAudiences {
+			return nil, newLoginError(http.StatusBadRequest, reasonTooManyAudiences, fmt.Errorf("token has %d audiences, more than the %d allowed by max_token_audiences", len(audiences), config.MaxTokenAudiences))
+		}
+	}
+
+	// deny the audiences the role blocks, before allowing the one it expects
+	if len(role.DeniedAudiences) > 0 {
+		audiences, _ := parsedJWT.Claims().Audience()
+		for _, audience := range audiences {
+			if strutil.StrListContains(role.DeniedAudiences, audience) {
+				return nil, newLoginError(http.StatusForbidden, reasonAudienceDenied, fmt.Errorf("audience %q denied", audience))
+			}
+		}
+	}
+
 	// validate the audience if the role expects it
 	if role.Audience != "" {
 		validator.SetAudience(role.Audience)
 	}
 
-	if err := validator.Validate(parsedJWT); err != nil {
-		return nil, err
+	done := timeStage(ctx, timingClaimValidation)
+	err = validator.Validate(parsedJWT)
+	done()
+	if err != nil {
+		return nil, jwtValidationError(err)
 	}
 
-	if config.EnableCustomMetadataFromAnnotations {
-		annotations, err := b.serviceAccountReaderFactory(config).ReadAnnotations(ctx, sa.name(), sa.namespace())
-		if err != nil {
-			return nil, fmt.Errorf("failed to read serviceaccount annotations: %v", err)
-		}
+	kid := jwtKid(jwtStr)
+	if config.RequireKid && kid == "" {
+		return nil, newLoginError(http.StatusForbidden, reasonKidMissing, errors.New("token missing kid header"))
+	}
 
-		sa.Annotations = annotations
+	if config.VerifyX5C {
+		if err := verifyEmbeddedCerts(jwtStr, config); err != nil {
+			return nil, err
+		}
 	}
 
 	// If we don't have any public keys to verify, return the sa and end early.
@@ -338,8 +1107,16 @@ func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string
 			}
 		}
 
-		// validates the signature and then runs the claim validation
-		if err := parsedJWT.Validate(cert, signingMethod); err != nil {
+		// the clock can't be trusted, only the signature is verified and the
+		// validity of the token is left to the TokenReview.
+		if config.TrustTokenReviewForExpiry {
+			return parsedJWT.(jws.JWS).Verify(cert, signingMethod)
+		}
+
+		// validates the signature and then runs the claim validation, the
+		// not before leeway and the role's nbf window only apply to the nbf
+		// claim and leave expiry validation untouched.
+		if err := parsedJWT.Validate(cert, signingMethod, &jwt.Validator{NBF: config.NotBeforeLeeway + role.NBFFutureWindow}); err != nil {
 			return err
 		}
 
@@ -347,11 +1124,15 @@ func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string
 	}
 
 	var validationErr error
-	// for each configured certificate run the verifyFunc
-	for _, cert := range config.PublicKeys {
+	// for each configured certificate run the verifyFunc, only the ones
+	// identified by the kid of the token are tried if there are any.
+	done = timeStage(ctx, timingSignatureVerification)
+	defer done()
+	for _, cert := range keysForKid(config.PublicKeys, kid) {
 		err := verifyFunc(cert)
 		switch err {
 		case nil:
+			sa.signatureVerified = true
 			return sa, nil
 		case rsa.ErrVerification, crypto.ErrECDSAVerification, errMismatchedSigningMethod:
 			// if the error is a failure to verify or a signing method mismatch
@@ -360,11 +1141,18 @@ func (b *kubeAuthBackend) parseAndValidateJWT(ctx context.Context, jwtStr string
 			validationErr = multierror.Append(validationErr, errwrap.Wrapf("failed to validate JWT: {{err}}", err))
 			continue
 		default:
-			return nil, err
+			return nil, jwtValidationError(err)
 		}
 	}
 
-	return nil, validationErr
+	// Leave the decision to the TokenReview, which is always performed for
+	// tokens whose signature was not verified.
+	if config.VerificationPrecedence == verificationPrecedenceReviewWins {
+		b.Logger().Warn("JWT signature verification failed, deferring to the TokenReview", "error", validationErr)
+		return sa, nil
+	}
+
+	return nil, newLoginError(http.StatusForbidden, reasonSignatureInvalid, validationErr)
 }
 
 // serviceAccount holds the metadata from the JWT token and is used to lookup
@@ -387,78 +1175,167 @@ type serviceAccount struct {
 	// which will be loaded here if `config.EnableCustomMetadataFromAnnotations` is
 	// enabled.
 	Annotations map[string]string
+
+	// claims holds all the claims of the JWT, once validated.
+	claims map[string]interface{}
+
+	// lowercase is set when the namespace and name are normalised to lowercase,
+	// see config.LowercaseNamespaceNameMatching.
+	lowercase bool
+
+	// signatureVerified is set once the signature of the JWT has been verified
+	// with one of the configured public keys.
+	signatureVerified bool
+
+	// tenant is the tenant split from the namespace by
+	// config.NamespacePrefixStrip, if any.
+	tenant string
+
+	// claimParseOrder is the order in which the projected and legacy claims
+	// are tried by identity, see config.ClaimParseOrder.
+	claimParseOrder string
 }
 
-// uid returns the UID for the service account, preferring the projected service
-// account value if found
-// return an error when the UID is empty.
-func (s *serviceAccount) uid() (string, error) {
-	uid := s.UID
-	if s.Kubernetes != nil && s.Kubernetes.ServiceAccount != nil {
-		uid = s.Kubernetes.ServiceAccount.UID
+// saIdentity is the identity of the service account of a token, normalised
+// from either its projected or its legacy claims.
+type saIdentity struct {
+	Namespace string
+	Name      string
+	UID       string
+	// Projected is set when the identity was read from the projected claims.
+	Projected bool
+}
+
+// parseClaims decodes the claims of a service account token, whatever its
+// shape. The identity of the service account is read from the claims in
+// order, see serviceAccount.identity.
+func parseClaims(claims map[string]interface{}, order string) (*serviceAccount, error) {
+	sa := &serviceAccount{
+		claims:          claims,
+		claimParseOrder: order,
 	}
+	if err := mapstructure.Decode(claims, sa); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
 
+// identity returns the identity of the service account read from the first
+// shape of claims, in claimParseOrder, the token carries. Claims missing from
+// that shape are not filled in from the other, so that a token carrying both
+// can't combine the identities of two service accounts.
+func (s *serviceAccount) identity() saIdentity {
+	projected := s.Kubernetes != nil
+	legacy := s.Name != "" || s.Namespace != "" || s.UID != ""
+	if projected && (!legacy || s.claimParseOrder != claimParseOrderLegacyFirst) {
+		id := saIdentity{
+			Namespace: s.Kubernetes.Namespace,
+			Projected: true,
+		}
+		if s.Kubernetes.ServiceAccount != nil {
+			id.Name = s.Kubernetes.ServiceAccount.Name
+			id.UID = s.Kubernetes.ServiceAccount.UID
+		}
+		return id
+	}
+	return saIdentity{
+		Namespace: s.Namespace,
+		Name:      s.Name,
+		UID:       s.UID,
+	}
+}
+
+// uid returns the UID for the service account, see identity.
+// return an error when the UID is empty.
+func (s *serviceAccount) uid() (string, error) {
+	uid := s.identity().UID
 	if uid == "" {
 		return "", errors.New("could not parse UID from claims")
 	}
 	return uid, nil
 }
 
-// name returns the name for the service account, preferring the projected
-// service account value if found. This is "default" for projected service
-// accounts
+// name returns the name for the service account, see identity. This is
+// "default" for projected service accounts
 func (s *serviceAccount) name() string {
-	if s.Kubernetes != nil && s.Kubernetes.ServiceAccount != nil {
-		return s.Kubernetes.ServiceAccount.Name
+	return s.normalise(s.identity().Name)
+}
+
+// nodeName returns the name of the node a projected token is bound to, or an
+// empty string if the token carries no node claim.
+func (s *serviceAccount) nodeName() string {
+	if s.Kubernetes != nil && s.Kubernetes.Node != nil {
+		return s.Kubernetes.Node.Name
 	}
-	return s.Name
+	return ""
 }
 
-// namespace returns the namespace for the service account, preferring the
-// projected service account value if found
+// namespace returns the namespace for the service account, see identity.
 func (s *serviceAccount) namespace() string {
-	if s.Kubernetes != nil {
-		return s.Kubernetes.Namespace
+	return s.normalise(s.identity().Namespace)
+}
+
+// normalise lowercases v if the service account is matched case-insensitively.
+func (s *serviceAccount) normalise(v string) string {
+	if s.lowercase {
+		return strings.ToLower(v)
 	}
-	return s.Namespace
+	return v
 }
 
 type projectedServiceToken struct {
 	Namespace      string        `mapstructure:"namespace"`
 	Pod            *k8sObjectRef `mapstructure:"pod"`
+	Node           *k8sObjectRef `mapstructure:"node"`
 	ServiceAccount *k8sObjectRef `mapstructure:"serviceaccount"`
 }
 
+// validate returns an error naming the first claim identifying the service
+// account which is missing from the projected token.
+func (p *projectedServiceToken) validate() error {
+	switch {
+	case p.Namespace == "":
+		return errors.New("missing kubernetes.io.namespace")
+	case p.ServiceAccount == nil:
+		return errors.New("missing kubernetes.io.serviceaccount")
+	case p.ServiceAccount.Name == "":
+		return errors.New("missing kubernetes.io.serviceaccount.name")
+	case p.ServiceAccount.UID == "":
+		return errors.New("missing kubernetes.io.serviceaccount.uid")
+	}
+	return nil
+}
+
 type k8sObjectRef struct {
 	Name string `mapstructure:"name"`
 	UID  string `mapstructure:"uid"`
 }
 
 // lookup calls the TokenReview API in kubernetes to verify the token and secret
-// still exist.
-func (s *serviceAccount) lookup(ctx context.Context, jwtStr string, tr tokenReviewer) error {
-	r, err := tr.Review(ctx, jwtStr, s.Audience)
+// still exist for the audiences, and returns the result of the review.
+func (s *serviceAccount) lookup(ctx context.Context, jwtStr string, audiences []string, tr tokenReviewer) (*tokenReviewResult, error) {
+	r, err := tr.Review(ctx, jwtStr, audiences)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Verify the returned metadata matches the expected data from the service
 	// account.
-	if s.name() != r.Name {
-		return errors.New("JWT names did not match")
+	if s.name() != s.normalise(r.Name) {
+		return nil, errors.New("JWT names did not match")
 	}
 	uid, err := s.uid()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if uid != r.UID {
-		return errors.New("JWT UIDs did not match")
+		return nil, errors.New("JWT UIDs did not match")
 	}
-	if s.namespace() != r.Namespace {
-		return errors.New("JWT namepaces did not match")
+	if s.namespace() != s.normalise(r.Namespace) {
+		return nil, errors.New("JWT namepaces did not match")
 	}
 
-	return nil
+	return r, nil
 }
 
 // Invoked when the token issued by this backend is attempting a renewal.
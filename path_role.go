@@ -2,7 +2,11 @@ package kubeauth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -43,22 +47,113 @@ are allowed.`,
 				"bound_service_account_namespaces": {
 					Type: framework.TypeCommaStringSlice,
 					Description: `List of namespaces allowed to access this role. If set to "*" all namespaces
-are allowed.`,
+are allowed. Can not be empty.`,
+				},
+				"require_explicit_bindings": {
+					Type: framework.TypeBool,
+					Description: `Deny logins while bound_service_account_names or bound_service_account_namespaces
+is "*", so that sensitive roles must enumerate the service accounts and
+namespaces they grant access to. Defaults to false.`,
 				},
 				"audience": {
 					Type:        framework.TypeString,
 					Description: "Optional Audience claim to verify in the jwt.",
 				},
+				"denied_audiences": {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Optional list of audiences which deny the login when present in the aud
+claim of the jwt, even if it also holds the allowed audience.`,
+				},
+				"bound_node_names": {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Optional list of node names, supporting globs, that projected tokens must be
+bound to via their "kubernetes.io.node" claim. If set, tokens without a node
+claim are rejected.`,
+				},
+				"bound_owner_references": {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Optional list of controllers, as Kind/name and supporting globs, e.g.
+"Deployment/payments-api", that the pod projected tokens are bound to must be
+owned by. Pods owned by a ReplicaSet are matched against the Deployment owning
+it. If set, tokens without a pod claim are rejected.`,
+				},
+				"require_claim": {
+					Type: framework.TypeKVPairs,
+					Description: `Optional map of dot separated JWT claim paths to the values, supporting globs,
+they must have. Claims holding a list must contain a matching value. Tokens
+missing one of the claims or not matching its value are rejected.`,
+				},
+				"metadata_templates": {
+					Type: framework.TypeKVPairs,
+					Description: `Optional map of metadata keys to Go text/template strings, evaluated against
+the validated JWT claims at login. Templates have access to .Namespace,
+.ServiceAccountName, .ServiceAccountUID and .Claims.`,
+				},
+				"suggest_response_wrapping_ttl": {
+					Type: framework.TypeDurationSecond,
+					Description: `Optional TTL that clients logging in against this role are advised to
+response-wrap the token with, surfaced as a warning and the
+suggested_response_wrapping_ttl metadata. Wrapping is not enforced.`,
+				},
+				"nbf_future_window": {
+					Type: framework.TypeDurationSecond,
+					Description: `Optional duration in seconds that the nbf claim of the JWTs logging in
+against this role may be ahead of the current time, the tokens are then
+accepted immediately. Applies on top of the not_before_leeway of the config.`,
+				},
+				"max_concurrent_logins": {
+					Type: framework.TypeInt,
+					Description: `Optional maximum number of logins against this role performing checks against
+the Kubernetes API at the same time. Logins beyond the limit wait until the
+request deadline, or fail immediately with a 429 if there is none. Defaults to
+0, which means unlimited.`,
+				},
+				"alias_metadata_keys": {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Optional list of the metadata keys to set on the entity alias. If unset, all
+the metadata is set on both the token and the alias.`,
+				},
+				"allowed_annotation_keys": {
+					Type: framework.TypeCommaStringSlice,
+					Description: `Optional list of the metadata keys, after normalisation to snake_case, that
+service account annotations may set for this role. If unset, all the
+annotations are used. Has no effect unless enable_custom_metadata_from_annotations
+or enable_pod_annotation_metadata is set in the config.`,
+				},
+				"enable_namespace_metadata": {
+					Type: framework.TypeBool,
+					Description: `If true, the labels of the service account's namespace listed in
+namespace_metadata_labels are set on the token and the alias, prefixed with
+"ns_label_". Requires permission to get namespaces in the Kubernetes API.`,
+				},
+				"namespace_metadata_labels": {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `Optional list of the namespace label keys to copy when enable_namespace_metadata is set.`,
+				},
+				"enable_pod_metadata": {
+					Type: framework.TypeBool,
+					Description: `If true, the name of the node the pod of a projected token is scheduled
+on is set on the token as the pod_node_name metadata. Requires permission to
+get pods in the Kubernetes API.`,
+				},
 				"alias_name_source": {
 					Type: framework.TypeString,
 					Description: fmt.Sprintf(`Source to use when deriving the Alias name.
 valid choices:
 	%q : <token.uid> e.g. 474b11b5-0f20-4f9d-8ca5-65715ab325e0 (most secure choice)
 	%q : <namespace>/<serviceaccount> e.g. vault/vault-agent
+	%q : the value of the JWT claim named by alias_name_claim
+A comma separated list of sources is tried in order and the first resolving
+one is used, e.g. "serviceaccount_uid,serviceaccount_name".
 default: %q
-`, aliasNameSourceSAUid, aliasNameSourceSAName, aliasNameSourceDefault),
+`, aliasNameSourceSAUid, aliasNameSourceSAName, aliasNameSourceClaim, aliasNameSourceDefault),
 					Default: aliasNameSourceDefault,
 				},
+				"alias_name_claim": {
+					Type: framework.TypeString,
+					Description: fmt.Sprintf(`Dot separated path of the JWT claim to use as the Alias name,
+e.g. "kubernetes.io.pod.name". Required when alias_name_source is %q.`, aliasNameSourceClaim),
+				},
 				"policies": {
 					Type:        framework.TypeCommaStringSlice,
 					Description: tokenutil.DeprecationText("token_policies"),
@@ -104,6 +199,38 @@ default: %q
 				Action:   "Create",
 			},
 		},
+		{
+			Pattern: "roles/delete-by-prefix$",
+			Fields: map[string]*framework.FieldSchema{
+				"prefix": {
+					Type:        framework.TypeString,
+					Description: "Prefix of the names of the roles to delete. This field is required.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRoleDeleteByPrefix,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["role-delete-by-prefix"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["role-delete-by-prefix"][1]),
+		},
+		{
+			Pattern: "roles/match$",
+			Fields: map[string]*framework.FieldSchema{
+				"namespace": {
+					Type:        framework.TypeString,
+					Description: "Namespace of the service account. This field is required.",
+				},
+				"service_account_name": {
+					Type:        framework.TypeString,
+					Description: "Name of the service account. This field is required.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathRoleMatch,
+			},
+			HelpSynopsis:    strings.TrimSpace(roleHelp["role-match"][0]),
+			HelpDescription: strings.TrimSpace(roleHelp["role-match"][1]),
+		},
 	}
 
 	tokenutil.AddTokenFields(p[1].Fields)
@@ -131,7 +258,10 @@ func (b *kubeAuthBackend) pathRoleList(ctx context.Context, req *logical.Request
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(roles), nil
+
+	resp := logical.ListResponse(roles)
+	resp.Data["count"] = len(roles)
+	return resp, nil
 }
 
 // pathRoleRead grabs a read lock and reads the options set on the role from the storage
@@ -162,6 +292,62 @@ func (b *kubeAuthBackend) pathRoleRead(ctx context.Context, req *logical.Request
 		d["audience"] = role.Audience
 	}
 
+	if len(role.DeniedAudiences) > 0 {
+		d["denied_audiences"] = role.DeniedAudiences
+	}
+
+	if len(role.NodeNames) > 0 {
+		d["bound_node_names"] = role.NodeNames
+	}
+
+	if len(role.OwnerReferences) > 0 {
+		d["bound_owner_references"] = role.OwnerReferences
+	}
+
+	if len(role.RequireClaim) > 0 {
+		d["require_claim"] = role.RequireClaim
+	}
+
+	if len(role.MetadataTemplates) > 0 {
+		d["metadata_templates"] = role.MetadataTemplates
+	}
+
+	if len(role.AliasMetadataKeys) > 0 {
+		d["alias_metadata_keys"] = role.AliasMetadataKeys
+	}
+
+	if role.MaxConcurrentLogins > 0 {
+		d["max_concurrent_logins"] = role.MaxConcurrentLogins
+	}
+
+	if len(role.AllowedAnnotationKeys) > 0 {
+		d["allowed_annotation_keys"] = role.AllowedAnnotationKeys
+	}
+
+	if role.EnableNamespaceMetadata {
+		d["enable_namespace_metadata"] = true
+	}
+
+	if len(role.NamespaceMetadataLabels) > 0 {
+		d["namespace_metadata_labels"] = role.NamespaceMetadataLabels
+	}
+
+	if role.EnablePodMetadata {
+		d["enable_pod_metadata"] = true
+	}
+
+	if role.SuggestResponseWrappingTTL > 0 {
+		d["suggest_response_wrapping_ttl"] = int64(role.SuggestResponseWrappingTTL.Seconds())
+	}
+
+	if role.NBFFutureWindow > 0 {
+		d["nbf_future_window"] = int64(role.NBFFutureWindow.Seconds())
+	}
+
+	if role.RequireExplicitBindings {
+		d["require_explicit_bindings"] = true
+	}
+
 	role.PopulateTokenData(d)
 
 	if len(role.Policies) > 0 {
@@ -184,6 +370,16 @@ func (b *kubeAuthBackend) pathRoleRead(ctx context.Context, req *logical.Request
 	}
 
 	d["alias_name_source"] = role.AliasNameSource
+	if role.AliasNameClaim != "" {
+		d["alias_name_claim"] = role.AliasNameClaim
+	}
+	d["export"] = role.export()
+	bindingsHash, err := role.bindingsHash()
+	if err != nil {
+		return nil, err
+	}
+	d["bindings_hash"] = bindingsHash
+	d["applied_defaults"] = role.appliedDefaults(b.System())
 
 	return &logical.Response{
 		Data: d,
@@ -205,10 +401,101 @@ func (b *kubeAuthBackend) pathRoleDelete(ctx context.Context, req *logical.Reque
 	if err := req.Storage.Delete(ctx, "role/"+strings.ToLower(roleName)); err != nil {
 		return nil, err
 	}
+	b.dropLoginSemaphore(strings.ToLower(roleName))
 
 	return nil, nil
 }
 
+// pathRoleDeleteByPrefix removes all the roles whose name starts with the
+// given prefix and returns their names.
+func (b *kubeAuthBackend) pathRoleDeleteByPrefix(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	prefix := strings.ToLower(data.Get("prefix").(string))
+	if prefix == "" {
+		return logical.ErrorResponse("missing prefix"), nil
+	}
+
+	// Acquire the lock before deleting the roles.
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	roles, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, roleName := range roles {
+		if !strings.HasPrefix(roleName, prefix) {
+			continue
+		}
+		if err := req.Storage.Delete(ctx, "role/"+roleName); err != nil {
+			return nil, err
+		}
+		b.dropLoginSemaphore(roleName)
+		deleted = append(deleted, roleName)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted": deleted,
+		},
+	}, nil
+}
+
+// pathRoleMatch returns the roles whose bindings admit the given service
+// account. Only the bound names and namespaces are evaluated, the constraints
+// checked against the token at login are not.
+func (b *kubeAuthBackend) pathRoleMatch(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	namespace := data.Get("namespace").(string)
+	if namespace == "" {
+		return logical.ErrorResponse("missing namespace"), nil
+	}
+	name := data.Get("service_account_name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing service_account_name"), nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.LowercaseNamespaceNameMatching {
+		namespace = strings.ToLower(namespace)
+		name = strings.ToLower(name)
+	}
+	if config != nil && config.NamespacePrefixStrip != "" {
+		_, namespace = splitNamespace(config.NamespacePrefixStripRegexp, namespace)
+	}
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	roles, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []string{}
+	for _, roleName := range roles {
+		role, err := b.role(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		if role.admitsNamespace(namespace) && role.admitsServiceAccountName(name) {
+			matched = append(matched, roleName)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"roles": matched,
+		},
+	}, nil
+}
+
 // pathRoleCreateUpdate registers a new role with the backend or updates the options
 // of an existing role
 func (b *kubeAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -226,9 +513,31 @@ func (b *kubeAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 		return nil, err
 	}
 
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil && config.StrictRoleFields {
+		if err := validateRoleFields(req.Data, data.Schema); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
 	// Create a new entry object if this is a CreateOperation
 	if role == nil && req.Operation == logical.CreateOperation {
-		role = &roleStorageEntry{}
+		if config != nil && config.MaxRoles > 0 {
+			roles, err := req.Storage.List(ctx, "role/")
+			if err != nil {
+				return nil, err
+			}
+			if len(roles) >= config.MaxRoles {
+				return logical.ErrorResponse("maximum number of roles (%d) reached", config.MaxRoles), nil
+			}
+		}
+		role = &roleStorageEntry{
+			Version: currentRoleVersion,
+		}
 	} else if role == nil {
 		return nil, fmt.Errorf("role entry not found during update operation")
 	}
@@ -296,33 +605,163 @@ func (b *kubeAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 	}
 
 	if namespaces, ok := data.GetOk("bound_service_account_namespaces"); ok {
-		role.ServiceAccountNamespaces = namespaces.([]string)
+		// Blank entries, e.g. from a trailing comma, match no namespace.
+		role.ServiceAccountNamespaces = strutil.RemoveEmpty(namespaces.([]string))
 	} else if req.Operation == logical.CreateOperation {
 		role.ServiceAccountNamespaces = data.Get("bound_service_account_namespaces").([]string)
 	}
-	// Verify namespaces is not empty
+	// Verify namespaces is not empty, all namespaces must be admitted
+	// explicitly with *
 	if len(role.ServiceAccountNamespaces) == 0 {
-		return logical.ErrorResponse("%q can not be empty", "bound_service_account_namespaces"), nil
+		return logical.ErrorResponse("%q can not be empty, set it to %q to admit all namespaces", "bound_service_account_namespaces", "*"), nil
 	}
 	// Verify * was not set with other data
 	if len(role.ServiceAccountNamespaces) > 1 && strutil.StrListContains(role.ServiceAccountNamespaces, "*") {
 		return logical.ErrorResponse("can not mix %q with values", "*"), nil
 	}
 
+	if requireExplicit, ok := data.GetOk("require_explicit_bindings"); ok {
+		role.RequireExplicitBindings = requireExplicit.(bool)
+	}
+	if role.RequireExplicitBindings && role.hasWildcardBinding() {
+		if resp == nil {
+			resp = &logical.Response{}
+		}
+		resp.AddWarning(fmt.Sprintf("role is bound to %q while %q is set, logins against it will be denied", "*", "require_explicit_bindings"))
+	}
+
 	// optional audience field
 	if audience, ok := data.GetOk("audience"); ok {
 		role.Audience = audience.(string)
 	}
 
+	if deniedAudiences, ok := data.GetOk("denied_audiences"); ok {
+		role.DeniedAudiences = deniedAudiences.([]string)
+	}
+	if role.Audience != "" && strutil.StrListContains(role.DeniedAudiences, role.Audience) {
+		return logical.ErrorResponse("%q can not contain the role's %q", "denied_audiences", "audience"), nil
+	}
+
+	if nodeNames, ok := data.GetOk("bound_node_names"); ok {
+		role.NodeNames = nodeNames.([]string)
+	}
+
+	if ownerReferences, ok := data.GetOk("bound_owner_references"); ok {
+		for _, ref := range ownerReferences.([]string) {
+			if err := validateOwnerReference(ref); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		role.OwnerReferences = ownerReferences.([]string)
+	}
+
+	if requireClaim, ok := data.GetOk("require_claim"); ok {
+		for claim := range requireClaim.(map[string]string) {
+			if claim == "" {
+				return logical.ErrorResponse("%q can not contain an empty claim", "require_claim"), nil
+			}
+		}
+		role.RequireClaim = requireClaim.(map[string]string)
+	}
+
+	if templates, ok := data.GetOk("metadata_templates"); ok {
+		for key, text := range templates.(map[string]string) {
+			if _, err := parseMetadataTemplate(key, text); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		role.MetadataTemplates = templates.(map[string]string)
+	}
+
+	if keys, ok := data.GetOk("alias_metadata_keys"); ok {
+		role.AliasMetadataKeys = keys.([]string)
+	}
+
+	if keys, ok := data.GetOk("allowed_annotation_keys"); ok {
+		role.AllowedAnnotationKeys = strutil.RemoveDuplicates(keys.([]string), false)
+	}
+
+	if enable, ok := data.GetOk("enable_namespace_metadata"); ok {
+		role.EnableNamespaceMetadata = enable.(bool)
+	}
+
+	if labels, ok := data.GetOk("namespace_metadata_labels"); ok {
+		role.NamespaceMetadataLabels = strutil.RemoveDuplicates(labels.([]string), false)
+	}
+
+	if enable, ok := data.GetOk("enable_pod_metadata"); ok {
+		role.EnablePodMetadata = enable.(bool)
+	}
+
+	if maxConcurrentLogins, ok := data.GetOk("max_concurrent_logins"); ok {
+		if maxConcurrentLogins.(int) < 0 {
+			return logical.ErrorResponse("%q can not be negative", "max_concurrent_logins"), nil
+		}
+		role.MaxConcurrentLogins = maxConcurrentLogins.(int)
+	}
+
+	if wrappingTTL, ok := data.GetOk("suggest_response_wrapping_ttl"); ok {
+		if wrappingTTL.(int) < 0 {
+			return logical.ErrorResponse("%q can not be negative", "suggest_response_wrapping_ttl"), nil
+		}
+		role.SuggestResponseWrappingTTL = time.Duration(wrappingTTL.(int)) * time.Second
+	}
+
+	if window, ok := data.GetOk("nbf_future_window"); ok {
+		if window.(int) < 0 {
+			return logical.ErrorResponse("%q can not be negative", "nbf_future_window"), nil
+		}
+		role.NBFFutureWindow = time.Duration(window.(int)) * time.Second
+	}
+
 	if source, ok := data.GetOk("alias_name_source"); ok {
 		if err := validateAliasNameSource(source.(string)); err != nil {
 			return logical.ErrorResponse(err.Error()), nil
 		}
-		role.AliasNameSource = source.(string)
+		role.AliasNameSource = strings.Join(splitAliasNameSources(source.(string)), ",")
 	} else if role.AliasNameSource == aliasNameSourceUnset {
 		role.AliasNameSource = data.Get("alias_name_source").(string)
 	}
 
+	if claim, ok := data.GetOk("alias_name_claim"); ok {
+		role.AliasNameClaim = claim.(string)
+	}
+	if strutil.StrListContains(splitAliasNameSources(role.AliasNameSource), aliasNameSourceClaim) && role.AliasNameClaim == "" {
+		return logical.ErrorResponse("%q must be set when %q is %q", "alias_name_claim", "alias_name_source", aliasNameSourceClaim), nil
+	}
+
+	// The tokens of a role without policies are only granted the default
+	// policy, which is almost always a mistake.
+	if config != nil && config.RejectEmptyPolicyRoles && len(role.TokenPolicies) == 0 && len(config.CommonPolicies) == 0 {
+		return logical.ErrorResponse("role has no policies and its tokens would only be granted the %q policy, set %q", "default", "token_policies"), nil
+	}
+
+	// Tokens of the role can't be used more often than the config allows, a
+	// token_num_uses of 0 means unlimited uses.
+	if config != nil && config.MaxNumUses > 0 {
+		if role.TokenNumUses == 0 {
+			return logical.ErrorResponse("%q is unlimited but the config sets %q to %d", "token_num_uses", "max_num_uses", config.MaxNumUses), nil
+		}
+		if role.TokenNumUses > config.MaxNumUses {
+			return logical.ErrorResponse("%q of %d exceeds the %q of %d", "token_num_uses", role.TokenNumUses, "max_num_uses", config.MaxNumUses), nil
+		}
+	}
+
+	warnings := roleConfigWarnings(role, config)
+	if config != nil && config.WarnOnAliasCollision {
+		collisions, err := b.aliasCollisionWarnings(ctx, req.Storage, roleName, role)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, collisions...)
+	}
+	for _, warning := range warnings {
+		if resp == nil {
+			resp = &logical.Response{}
+		}
+		resp.AddWarning(warning)
+	}
+
 	// Store the entry.
 	entry, err := logical.StorageEntryJSON("role/"+strings.ToLower(roleName), role)
 	if err != nil {
@@ -338,6 +777,228 @@ func (b *kubeAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical
 	return resp, nil
 }
 
+// validateRoleFields returns an error naming the first field in raw which is
+// not part of the schema, along with the closest known field.
+func validateRoleFields(raw map[string]interface{}, schema map[string]*framework.FieldSchema) error {
+	var unknown []string
+	for key := range raw {
+		if _, ok := schema[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+
+	known := make([]string, 0, len(schema))
+	for key := range schema {
+		known = append(known, key)
+	}
+	sort.Strings(known)
+
+	// Prefer the fields the unknown one abbreviates, e.g. bound_sa_names, then
+	// fall back to the closest by edit distance.
+	suggestion, best, abbreviates := "", -1, false
+	for _, key := range known {
+		d := levenshtein(unknown[0], key)
+		a := isAbbreviation(unknown[0], key)
+		if best == -1 || (a && !abbreviates) || (a == abbreviates && d < best) {
+			suggestion, best, abbreviates = key, d, a
+		}
+	}
+
+	return fmt.Errorf("unknown field %q; did you mean %q?", unknown[0], suggestion)
+}
+
+// isAbbreviation returns whether the characters of abbr, ignoring
+// underscores, appear in order in name.
+func isAbbreviation(abbr, name string) bool {
+	abbr = strings.ReplaceAll(abbr, "_", "")
+	i := 0
+	for j := 0; i < len(abbr) && j < len(name); j++ {
+		if abbr[i] == name[j] {
+			i++
+		}
+	}
+	return i == len(abbr)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// roleConfigWarnings returns warnings about settings of the role which are
+// inconsistent with the backend configuration, so that misconfigurations are
+// surfaced when the role is written rather than at login time.
+func roleConfigWarnings(role *roleStorageEntry, config *kubeConfig) []string {
+	if config == nil {
+		return []string{"backend has not been configured; logins against this role will fail until config is written"}
+	}
+
+	var warnings []string
+	if len(role.AllowedAnnotationKeys) > 0 && !config.EnableCustomMetadataFromAnnotations && !config.EnablePodAnnotationMetadata {
+		warnings = append(warnings, "role requests annotation metadata through allowed_annotation_keys but config has it disabled; set enable_custom_metadata_from_annotations or enable_pod_annotation_metadata")
+	}
+	return warnings
+}
+
+// aliasCollisionWarnings returns a warning for each other role with the same
+// bindings and alias name source as role but different policies. Logins with
+// either role resolve to the same alias, and so the same entity, while being
+// granted different policies.
+func (b *kubeAuthBackend) aliasCollisionWarnings(ctx context.Context, s logical.Storage, roleName string, role *roleStorageEntry) ([]string, error) {
+	roles, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, name := range roles {
+		if name == strings.ToLower(roleName) {
+			continue
+		}
+		other, err := b.role(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if other == nil || !role.sharesAliases(other) {
+			continue
+		}
+		if !strutil.EquivalentSlices(role.TokenPolicies, other.TokenPolicies) {
+			warnings = append(warnings, fmt.Sprintf("role %q has the same bindings and alias_name_source but different policies, logins with either role share an alias and entity", name))
+		}
+	}
+	return warnings, nil
+}
+
+// sharesAliases reports whether the logins admitted by r and other resolve to
+// the same aliases, i.e. both roles have the same bindings and alias name
+// source.
+func (r *roleStorageEntry) sharesAliases(other *roleStorageEntry) bool {
+	if !strutil.EquivalentSlices(r.ServiceAccountNames, other.ServiceAccountNames) ||
+		!strutil.EquivalentSlices(r.ServiceAccountNamespaces, other.ServiceAccountNamespaces) {
+		return false
+	}
+	if r.AliasNameSource != other.AliasNameSource {
+		return false
+	}
+	return r.AliasNameClaim == other.AliasNameClaim
+}
+
+// export returns the role in a normalised form which can be written back to
+// the role endpoint verbatim. Deprecated fields are folded into their token_*
+// equivalents and unset optional fields are omitted.
+func (r *roleStorageEntry) export() map[string]interface{} {
+	d := map[string]interface{}{
+		"bound_service_account_names":      r.ServiceAccountNames,
+		"bound_service_account_namespaces": r.ServiceAccountNamespaces,
+		"alias_name_source":                r.AliasNameSource,
+		"token_type":                       r.TokenType.String(),
+		"token_no_default_policy":          r.TokenNoDefaultPolicy,
+	}
+
+	if r.Audience != "" {
+		d["audience"] = r.Audience
+	}
+	if len(r.DeniedAudiences) > 0 {
+		d["denied_audiences"] = r.DeniedAudiences
+	}
+	if len(r.NodeNames) > 0 {
+		d["bound_node_names"] = r.NodeNames
+	}
+	if len(r.OwnerReferences) > 0 {
+		d["bound_owner_references"] = r.OwnerReferences
+	}
+	if len(r.RequireClaim) > 0 {
+		d["require_claim"] = r.RequireClaim
+	}
+	if len(r.MetadataTemplates) > 0 {
+		d["metadata_templates"] = r.MetadataTemplates
+	}
+	if len(r.AliasMetadataKeys) > 0 {
+		d["alias_metadata_keys"] = r.AliasMetadataKeys
+	}
+	if r.MaxConcurrentLogins > 0 {
+		d["max_concurrent_logins"] = r.MaxConcurrentLogins
+	}
+	if len(r.AllowedAnnotationKeys) > 0 {
+		d["allowed_annotation_keys"] = r.AllowedAnnotationKeys
+	}
+	if r.EnableNamespaceMetadata {
+		d["enable_namespace_metadata"] = true
+	}
+	if len(r.NamespaceMetadataLabels) > 0 {
+		d["namespace_metadata_labels"] = r.NamespaceMetadataLabels
+	}
+	if r.EnablePodMetadata {
+		d["enable_pod_metadata"] = true
+	}
+	if r.SuggestResponseWrappingTTL > 0 {
+		d["suggest_response_wrapping_ttl"] = int64(r.SuggestResponseWrappingTTL.Seconds())
+	}
+	if r.NBFFutureWindow > 0 {
+		d["nbf_future_window"] = int64(r.NBFFutureWindow.Seconds())
+	}
+	if r.RequireExplicitBindings {
+		d["require_explicit_bindings"] = true
+	}
+	if r.AliasNameClaim != "" {
+		d["alias_name_claim"] = r.AliasNameClaim
+	}
+
+	if len(r.TokenPolicies) > 0 {
+		d["token_policies"] = r.TokenPolicies
+	}
+	if len(r.TokenBoundCIDRs) > 0 {
+		cidrs := make([]string, len(r.TokenBoundCIDRs))
+		for i, cidr := range r.TokenBoundCIDRs {
+			cidrs[i] = cidr.String()
+		}
+		d["token_bound_cidrs"] = cidrs
+	}
+	if r.TokenTTL > 0 {
+		d["token_ttl"] = int64(r.TokenTTL.Seconds())
+	}
+	if r.TokenMaxTTL > 0 {
+		d["token_max_ttl"] = int64(r.TokenMaxTTL.Seconds())
+	}
+	if r.TokenExplicitMaxTTL > 0 {
+		d["token_explicit_max_ttl"] = int64(r.TokenExplicitMaxTTL.Seconds())
+	}
+	if r.TokenPeriod > 0 {
+		d["token_period"] = int64(r.TokenPeriod.Seconds())
+	}
+	if r.TokenNumUses > 0 {
+		d["token_num_uses"] = r.TokenNumUses
+	}
+
+	return d
+}
+
 // roleStorageEntry stores all the options that are set on an role
 type roleStorageEntry struct {
 	tokenutil.TokenParams
@@ -350,12 +1011,69 @@ type roleStorageEntry struct {
 	// role.
 	ServiceAccountNamespaces []string `json:"bound_service_account_namespaces" mapstructure:"bound_service_account_namespaces" structs:"bound_service_account_namespaces"`
 
+	// RequireExplicitBindings denies logins while the role is bound to all
+	// service account names or namespaces.
+	RequireExplicitBindings bool `json:"require_explicit_bindings" mapstructure:"require_explicit_bindings" structs:"require_explicit_bindings"`
+
 	// Audience is an optional jwt claim to verify
-	Audience string `json:"audience" mapstructure:"audience" structs: "audience"`
+	Audience string `json:"audience" mapstructure:"audience" structs:"audience"`
+
+	// DeniedAudiences is an optional array of audiences denying the login
+	// when present in the jwt.
+	DeniedAudiences []string `json:"denied_audiences" mapstructure:"denied_audiences" structs:"denied_audiences"`
+
+	// NodeNames is an optional array of node names, projected tokens must be
+	// bound to one of them.
+	NodeNames []string `json:"bound_node_names" mapstructure:"bound_node_names" structs:"bound_node_names"`
+
+	// OwnerReferences is an optional array of controllers, as Kind/name,
+	// the pods projected tokens are bound to must be owned by.
+	OwnerReferences []string `json:"bound_owner_references" mapstructure:"bound_owner_references" structs:"bound_owner_references"`
+
+	// RequireClaim maps JWT claim paths to the values, supporting globs, they
+	// must have.
+	RequireClaim map[string]string `json:"require_claim" mapstructure:"require_claim" structs:"require_claim"`
+
+	// MetadataTemplates maps metadata keys to templates evaluated against the
+	// JWT claims at login.
+	MetadataTemplates map[string]string `json:"metadata_templates" mapstructure:"metadata_templates" structs:"metadata_templates"`
+
+	// MaxConcurrentLogins optionally limits the concurrent logins checked
+	// against the Kubernetes API for this role.
+	MaxConcurrentLogins int `json:"max_concurrent_logins" mapstructure:"max_concurrent_logins" structs:"max_concurrent_logins"`
+
+	// SuggestResponseWrappingTTL is the TTL clients are advised to
+	// response-wrap the token with.
+	SuggestResponseWrappingTTL time.Duration `json:"suggest_response_wrapping_ttl" mapstructure:"suggest_response_wrapping_ttl" structs:"suggest_response_wrapping_ttl"`
+
+	// NBFFutureWindow is how far ahead the nbf claim of the role's tokens
+	// may be, in addition to the not before leeway of the config.
+	NBFFutureWindow time.Duration `json:"nbf_future_window" mapstructure:"nbf_future_window" structs:"nbf_future_window"`
+
+	// AliasMetadataKeys optionally restricts the metadata keys set on the
+	// entity alias.
+	AliasMetadataKeys []string `json:"alias_metadata_keys" mapstructure:"alias_metadata_keys" structs:"alias_metadata_keys"`
+
+	// AllowedAnnotationKeys optionally restricts the metadata keys set from
+	// service account annotations.
+	AllowedAnnotationKeys []string `json:"allowed_annotation_keys" mapstructure:"allowed_annotation_keys" structs:"allowed_annotation_keys"`
+
+	// EnableNamespaceMetadata copies the NamespaceMetadataLabels of the
+	// service account's namespace into the metadata.
+	EnableNamespaceMetadata bool     `json:"enable_namespace_metadata" mapstructure:"enable_namespace_metadata" structs:"enable_namespace_metadata"`
+	NamespaceMetadataLabels []string `json:"namespace_metadata_labels" mapstructure:"namespace_metadata_labels" structs:"namespace_metadata_labels"`
+
+	// EnablePodMetadata sets the node the pod of the token is scheduled on
+	// in the metadata.
+	EnablePodMetadata bool `json:"enable_pod_metadata" mapstructure:"enable_pod_metadata" structs:"enable_pod_metadata"`
 
 	// AliasNameSource used when deriving the Alias' name.
 	AliasNameSource string `json:"alias_name_source" mapstructure:"alias_name_source" structs:"alias_name_source"`
 
+	// AliasNameClaim is the dot separated path of the claim used as the
+	// Alias' name when AliasNameSource is aliasNameSourceClaim.
+	AliasNameClaim string `json:"alias_name_claim" mapstructure:"alias_name_claim" structs:"alias_name_claim"`
+
 	// Deprecated by TokenParams
 	Policies   []string      `json:"policies" structs:"policies" mapstructure:"policies"`
 	NumUses    int           `json:"num_uses" mapstructure:"num_uses" structs:"num_uses"`
@@ -363,6 +1081,110 @@ type roleStorageEntry struct {
 	MaxTTL     time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
 	Period     time.Duration `json:"period" mapstructure:"period" structs:"period"`
 	BoundCIDRs []*sockaddr.SockAddrMarshaler
+
+	// Version is the version of the stored role, see upgradeRole.
+	Version int `json:"version" mapstructure:"version" structs:"version"`
+}
+
+// appliedDefaults returns the optional fields of the role which are at their
+// defaults, keyed by field name, with the value in effect for them. Token TTLs
+// left unset are reported as the mount's TTLs they fall back to. New scalar
+// options of the role must be added here, list options default to empty.
+func (r *roleStorageEntry) appliedDefaults(sys logical.SystemView) map[string]interface{} {
+	d := make(map[string]interface{})
+
+	if r.AliasNameSource == aliasNameSourceDefault {
+		d["alias_name_source"] = aliasNameSourceDefault
+	}
+	if r.MaxConcurrentLogins == 0 {
+		d["max_concurrent_logins"] = 0
+	}
+	if !r.RequireExplicitBindings {
+		d["require_explicit_bindings"] = false
+	}
+	if r.SuggestResponseWrappingTTL == 0 {
+		d["suggest_response_wrapping_ttl"] = int64(0)
+	}
+	if r.NBFFutureWindow == 0 {
+		d["nbf_future_window"] = int64(0)
+	}
+	if !r.EnableNamespaceMetadata {
+		d["enable_namespace_metadata"] = false
+	}
+	if !r.EnablePodMetadata {
+		d["enable_pod_metadata"] = false
+	}
+	if r.TokenTTL == 0 {
+		d["token_ttl"] = int64(sys.DefaultLeaseTTL().Seconds())
+	}
+	if r.TokenMaxTTL == 0 {
+		d["token_max_ttl"] = int64(sys.MaxLeaseTTL().Seconds())
+	}
+	if r.TokenNumUses == 0 {
+		d["token_num_uses"] = 0
+	}
+	if r.TokenPeriod == 0 {
+		d["token_period"] = int64(0)
+	}
+	if r.TokenType == logical.TokenTypeDefault {
+		d["token_type"] = logical.TokenTypeDefault.String()
+	}
+
+	return d
+}
+
+// bindingsHash returns a hex SHA-256 hash over the bindings of the role, its
+// bound service account names, namespaces, node names and owner references
+// and its audiences. The lists are sorted and deduplicated first, so that the
+// hash only changes when these bindings do. Other options restricting logins,
+// such as require_claim and token_bound_cidrs, are not part of it.
+func (r *roleStorageEntry) bindingsHash() (string, error) {
+	normalise := func(list []string) []string {
+		list = strutil.RemoveDuplicates(append([]string(nil), list...), false)
+		sort.Strings(list)
+		return list
+	}
+	bindings := map[string]interface{}{
+		"bound_service_account_names":      normalise(r.ServiceAccountNames),
+		"bound_service_account_namespaces": normalise(r.ServiceAccountNamespaces),
+		"bound_node_names":                 normalise(r.NodeNames),
+		"bound_owner_references":           normalise(r.OwnerReferences),
+		"audience":                         r.Audience,
+		"denied_audiences":                 normalise(r.DeniedAudiences),
+	}
+
+	// Maps are encoded with sorted keys, keeping the encoding stable.
+	encoded, err := json.Marshal(bindings)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// admitsNamespace reports whether the bound namespaces of the role admit
+// namespace.
+func (r *roleStorageEntry) admitsNamespace(namespace string) bool {
+	if len(r.ServiceAccountNamespaces) == 1 && r.ServiceAccountNamespaces[0] == "*" {
+		return true
+	}
+	return strutil.StrListContainsGlob(r.ServiceAccountNamespaces, namespace)
+}
+
+// admitsServiceAccountName reports whether the bound service account names of
+// the role admit name.
+func (r *roleStorageEntry) admitsServiceAccountName(name string) bool {
+	if len(r.ServiceAccountNames) == 1 && r.ServiceAccountNames[0] == "*" {
+		return true
+	}
+	return strutil.StrListContainsGlob(r.ServiceAccountNames, name)
+}
+
+// hasWildcardBinding reports whether the role is bound to all service account
+// names or namespaces.
+func (r *roleStorageEntry) hasWildcardBinding() bool {
+	return (len(r.ServiceAccountNames) == 1 && r.ServiceAccountNames[0] == "*") ||
+		(len(r.ServiceAccountNamespaces) == 1 && r.ServiceAccountNamespaces[0] == "*")
 }
 
 var roleHelp = map[string][2]string{
@@ -377,4 +1199,16 @@ var roleHelp = map[string][2]string{
 		The bindings, token polices and token settings can all be configured
 		using this endpoint`,
 	},
+	"role-delete-by-prefix": {
+		"Delete all the roles whose name starts with a prefix.",
+		`Deletes every role whose name starts with the given prefix and returns
+		the names of the deleted roles. An empty prefix is refused.`,
+	},
+	"role-match": {
+		"List the roles a service account could log in with.",
+		`Returns the names of the roles whose bound_service_account_names and
+		bound_service_account_namespaces admit the given service account. The
+		other constraints of the roles, which depend on the token, are not
+		evaluated.`,
+	},
 }
@@ -71,6 +71,7 @@ func TestPath_Create(t *testing.T) {
 				NumUses:                  12,
 				BoundCIDRs:               nil,
 				AliasNameSource:          aliasNameSourceDefault,
+				Version:                  currentRoleVersion,
 			},
 		},
 		"alias_name_source_serviceaccount_name": {
@@ -102,8 +103,139 @@ func TestPath_Create(t *testing.T) {
 				NumUses:                  12,
 				BoundCIDRs:               nil,
 				AliasNameSource:          aliasNameSourceSAName,
+				Version:                  currentRoleVersion,
 			},
 		},
+		"bound_node_names": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"bound_node_names":                 "trusted-*,gpu-1",
+				"policies":                         "test",
+			},
+			expected: &roleStorageEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenPolicies: []string{"test"},
+				},
+				Policies:                 []string{"test"},
+				ServiceAccountNames:      []string{"name"},
+				ServiceAccountNamespaces: []string{"namespace"},
+				NodeNames:                []string{"trusted-*", "gpu-1"},
+				AliasNameSource:          aliasNameSourceDefault,
+				Version:                  currentRoleVersion,
+			},
+		},
+		"metadata_templates": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"metadata_templates":               map[string]interface{}{"workload": "{{ .Namespace }}/{{ .ServiceAccountName }}"},
+				"policies":                         "test",
+			},
+			expected: &roleStorageEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenPolicies: []string{"test"},
+				},
+				Policies:                 []string{"test"},
+				ServiceAccountNames:      []string{"name"},
+				ServiceAccountNamespaces: []string{"namespace"},
+				MetadataTemplates:        map[string]string{"workload": "{{ .Namespace }}/{{ .ServiceAccountName }}"},
+				AliasNameSource:          aliasNameSourceDefault,
+				Version:                  currentRoleVersion,
+			},
+		},
+		"metadata_templates_reserved_key": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"metadata_templates":               map[string]interface{}{"role": "{{ .Namespace }}"},
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`metadata key "role" is reserved`),
+		},
+		"metadata_templates_namespace_label_key": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"metadata_templates":               map[string]interface{}{"ns_label_team": "{{ .Namespace }}"},
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`metadata key "ns_label_team" is reserved`),
+		},
+		"metadata_templates_invalid": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"metadata_templates":               map[string]interface{}{"workload": "{{ .Namespace "},
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`invalid metadata template for "workload": template: workload:1: unclosed action`),
+		},
+		"alias_name_claim": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"alias_name_source":                aliasNameSourceClaim,
+				"alias_name_claim":                 "workload_id",
+				"policies":                         "test",
+			},
+			expected: &roleStorageEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenPolicies: []string{"test"},
+				},
+				Policies:                 []string{"test"},
+				ServiceAccountNames:      []string{"name"},
+				ServiceAccountNamespaces: []string{"namespace"},
+				AliasNameSource:          aliasNameSourceClaim,
+				Version:                  currentRoleVersion,
+				AliasNameClaim:           "workload_id",
+			},
+		},
+		"alias_name_source_priority": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"alias_name_source":                "serviceaccount_uid, serviceaccount_name",
+				"policies":                         "test",
+			},
+			expected: &roleStorageEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenPolicies: []string{"test"},
+				},
+				Policies:                 []string{"test"},
+				ServiceAccountNames:      []string{"name"},
+				ServiceAccountNamespaces: []string{"namespace"},
+				AliasNameSource:          "serviceaccount_uid,serviceaccount_name",
+				Version:                  currentRoleVersion,
+			},
+		},
+		"alias_name_source_priority_invalid": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"alias_name_source":                "serviceaccount_uid,_invalid_",
+				"policies":                         "test",
+			},
+			wantErr: errInvalidAliasNameSource,
+		},
+		"alias_name_source_priority_claim_missing": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"alias_name_source":                "serviceaccount_uid,claim",
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`"alias_name_claim" must be set when "alias_name_source" is "claim"`),
+		},
+		"alias_name_claim_missing": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+				"alias_name_source":                aliasNameSourceClaim,
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`"alias_name_claim" must be set when "alias_name_source" is "claim"`),
+		},
 		"invalid_alias_name_source": {
 			data: map[string]interface{}{
 				"bound_service_account_names":      "name",
@@ -128,7 +260,40 @@ func TestPath_Create(t *testing.T) {
 				"bound_service_account_names": "name",
 				"policies":                    "test",
 			},
-			wantErr: errors.New(`"bound_service_account_namespaces" can not be empty`),
+			wantErr: errors.New(`"bound_service_account_namespaces" can not be empty, set it to "*" to admit all namespaces`),
+		},
+		"empty_service_account_namespaces": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "",
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`"bound_service_account_namespaces" can not be empty, set it to "*" to admit all namespaces`),
+		},
+		"blank_service_account_namespaces": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": []string{" ", ""},
+				"policies":                         "test",
+			},
+			wantErr: errors.New(`"bound_service_account_namespaces" can not be empty, set it to "*" to admit all namespaces`),
+		},
+		"wildcard_service_account_namespaces": {
+			data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "*",
+				"policies":                         "test",
+			},
+			expected: &roleStorageEntry{
+				TokenParams: tokenutil.TokenParams{
+					TokenPolicies: []string{"test"},
+				},
+				Policies:                 []string{"test"},
+				ServiceAccountNames:      []string{"name"},
+				ServiceAccountNamespaces: []string{"*"},
+				AliasNameSource:          aliasNameSourceDefault,
+				Version:                  currentRoleVersion,
+			},
 		},
 		"mixed_splat_values_names": {
 			data: map[string]interface{}{
@@ -192,6 +357,368 @@ func TestPath_Create(t *testing.T) {
 	}
 }
 
+func TestPath_CreateConfigWarnings(t *testing.T) {
+	b, storage := getBackend(t)
+
+	roleData := map[string]interface{}{
+		"bound_service_account_names":      "name",
+		"bound_service_account_namespaces": "namespace",
+		"policies":                         "test",
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data:      roleData,
+	}
+
+	// test warning without config
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp == nil || len(resp.Warnings) != 1 {
+		t.Fatalf("expected a single warning, got %#v", resp)
+	}
+	if resp.Warnings[0] != "backend has not been configured; logins against this role will fail until config is written" {
+		t.Fatalf("unexpected warning: %s", resp.Warnings[0])
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host":    "host",
+			"kubernetes_ca_cert": testCACert,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// test no warning with config
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data:      roleData,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp != nil && len(resp.Warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", resp.Warnings)
+	}
+}
+
+func TestPath_CreateAnnotationMetadataWarnings(t *testing.T) {
+	const wantWarning = "role requests annotation metadata through allowed_annotation_keys but config has it disabled; set enable_custom_metadata_from_annotations or enable_pod_annotation_metadata"
+
+	// Each step writes the config, then the role, and expects the warning
+	// only while no annotation metadata is enabled.
+	type step struct {
+		config      map[string]interface{}
+		wantWarning bool
+	}
+	testCases := map[string][]step{
+		"disabled after the role is written": {
+			{config: map[string]interface{}{"enable_custom_metadata_from_annotations": true}},
+			{config: map[string]interface{}{"enable_custom_metadata_from_annotations": false}, wantWarning: true},
+		},
+		"enabled after the role is written": {
+			{config: map[string]interface{}{}, wantWarning: true},
+			{config: map[string]interface{}{"enable_custom_metadata_from_annotations": true}},
+		},
+		"pod annotations": {
+			{config: map[string]interface{}{"enable_pod_annotation_metadata": true}},
+			{config: map[string]interface{}{"enable_pod_annotation_metadata": false}, wantWarning: true},
+		},
+	}
+
+	for name, steps := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b, storage := getBackend(t)
+
+			for i, step := range steps {
+				config := map[string]interface{}{
+					"kubernetes_host":    "host",
+					"kubernetes_ca_cert": testCACert,
+				}
+				for k, v := range step.config {
+					config[k] = v
+				}
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      configPath,
+					Storage:   storage,
+					Data:      config,
+				})
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatalf("err:%s resp:%#v\n", err, resp)
+				}
+
+				var op logical.Operation = logical.UpdateOperation
+				if i == 0 {
+					op = logical.CreateOperation
+				}
+				resp, err = b.HandleRequest(context.Background(), &logical.Request{
+					Operation: op,
+					Path:      "role/plugin-test",
+					Storage:   storage,
+					Data: map[string]interface{}{
+						"bound_service_account_names":      "name",
+						"bound_service_account_namespaces": "namespace",
+						"policies":                         "test",
+						"allowed_annotation_keys":          "team",
+					},
+				})
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatalf("err:%s resp:%#v\n", err, resp)
+				}
+
+				var warnings []string
+				if resp != nil {
+					warnings = resp.Warnings
+				}
+				var want []string
+				if step.wantWarning {
+					want = []string{wantWarning}
+				}
+				if diff := deep.Equal(want, warnings); diff != nil {
+					t.Fatalf("step %d: %v", i, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestPath_RejectEmptyPolicyRoles(t *testing.T) {
+	b, storage := getBackend(t)
+
+	testCases := map[string]struct {
+		config  map[string]interface{}
+		role    map[string]interface{}
+		wantErr string
+	}{
+		"no_policies": {
+			config:  map[string]interface{}{"reject_empty_policy_roles": true},
+			wantErr: `role has no policies and its tokens would only be granted the "default" policy, set "token_policies"`,
+		},
+		"policies": {
+			config: map[string]interface{}{"reject_empty_policy_roles": true},
+			role:   map[string]interface{}{"token_policies": "read"},
+		},
+		"legacy_policies": {
+			config: map[string]interface{}{"reject_empty_policy_roles": true},
+			role:   map[string]interface{}{"policies": "read"},
+		},
+		"common_policies": {
+			config: map[string]interface{}{
+				"reject_empty_policy_roles": true,
+				"common_policies":           "audit",
+			},
+		},
+		"disabled": {},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			configData := map[string]interface{}{
+				"kubernetes_host":    "host",
+				"kubernetes_ca_cert": testCACert,
+			}
+			for k, v := range tc.config {
+				configData[k] = v
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data:      configData,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			roleData := map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+			}
+			for k, v := range tc.role {
+				roleData[k] = v
+			}
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/" + name,
+				Storage:   storage,
+				Data:      roleData,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr == "" {
+				if resp != nil && resp.IsError() {
+					t.Fatalf("unexpected error response: %#v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+			}
+		})
+	}
+}
+
+func TestPath_MaxNumUses(t *testing.T) {
+	b, storage := getBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host":    "host",
+			"kubernetes_ca_cert": testCACert,
+			"max_num_uses":       5,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	testCases := map[string]struct {
+		role    map[string]interface{}
+		wantErr string
+	}{
+		"within": {
+			role: map[string]interface{}{"token_num_uses": 3},
+		},
+		"at_ceiling": {
+			role: map[string]interface{}{"token_num_uses": 5},
+		},
+		"legacy_num_uses": {
+			role: map[string]interface{}{"num_uses": 5},
+		},
+		"beyond": {
+			role:    map[string]interface{}{"token_num_uses": 6},
+			wantErr: `"token_num_uses" of 6 exceeds the "max_num_uses" of 5`,
+		},
+		"unlimited": {
+			wantErr: `"token_num_uses" is unlimited but the config sets "max_num_uses" to 5`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			roleData := map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+			}
+			for k, v := range tc.role {
+				roleData[k] = v
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/" + name,
+				Storage:   storage,
+				Data:      roleData,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr == "" {
+				if resp != nil && resp.IsError() {
+					t.Fatalf("unexpected error response: %#v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+			}
+		})
+	}
+}
+
+func TestPath_AliasCollisionWarnings(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host":         "host",
+			"kubernetes_ca_cert":      testCACert,
+			"warn_on_alias_collision": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	writeRole := func(name string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		roleData := map[string]interface{}{
+			"bound_service_account_names":      "name",
+			"bound_service_account_namespaces": "namespace,other",
+		}
+		for k, v := range data {
+			roleData[k] = v
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data:      roleData,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	if resp := writeRole("reader", map[string]interface{}{"token_policies": "read"}); resp != nil {
+		t.Fatalf("expected no warnings, got %#v", resp.Warnings)
+	}
+
+	// The same policies and different bindings or alias sources don't collide.
+	if resp := writeRole("reader-copy", map[string]interface{}{"token_policies": "read"}); resp != nil {
+		t.Fatalf("expected no warnings, got %#v", resp.Warnings)
+	}
+	if resp := writeRole("other-namespaces", map[string]interface{}{
+		"bound_service_account_namespaces": "namespace",
+		"token_policies":                   "write",
+	}); resp != nil {
+		t.Fatalf("expected no warnings, got %#v", resp.Warnings)
+	}
+	if resp := writeRole("by-name", map[string]interface{}{
+		"alias_name_source": aliasNameSourceSAName,
+		"token_policies":    "write",
+	}); resp != nil {
+		t.Fatalf("expected no warnings, got %#v", resp.Warnings)
+	}
+
+	resp = writeRole("writer", map[string]interface{}{
+		"bound_service_account_namespaces": "other,namespace",
+		"token_policies":                   "write",
+	})
+	want := []string{
+		`role "reader" has the same bindings and alias_name_source but different policies, logins with either role share an alias and entity`,
+		`role "reader-copy" has the same bindings and alias_name_source but different policies, logins with either role share an alias and entity`,
+	}
+	if resp == nil {
+		t.Fatal("expected warnings")
+	}
+	if diff := deep.Equal(want, resp.Warnings); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
 func TestPath_Read(t *testing.T) {
 	b, storage := getBackend(t)
 
@@ -205,6 +732,14 @@ func TestPath_Read(t *testing.T) {
 		"max_ttl":                          "5s",
 	}
 
+	bindingsHash, err := (&roleStorageEntry{
+		ServiceAccountNames:      []string{"name"},
+		ServiceAccountNamespaces: []string{"namespace"},
+	}).bindingsHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	expected := map[string]interface{}{
 		"bound_service_account_names":      []string{"name"},
 		"bound_service_account_namespaces": []string{"namespace"},
@@ -223,6 +758,29 @@ func TestPath_Read(t *testing.T) {
 		"token_explicit_max_ttl":           int64(0),
 		"token_no_default_policy":          false,
 		"alias_name_source":                aliasNameSourceDefault,
+		"export": map[string]interface{}{
+			"bound_service_account_names":      []string{"name"},
+			"bound_service_account_namespaces": []string{"namespace"},
+			"token_policies":                   []string{"test"},
+			"token_period":                     int64(3),
+			"token_ttl":                        int64(1),
+			"token_num_uses":                   12,
+			"token_max_ttl":                    int64(5),
+			"token_type":                       logical.TokenTypeDefault.String(),
+			"token_no_default_policy":          false,
+			"alias_name_source":                aliasNameSourceDefault,
+		},
+		"applied_defaults": map[string]interface{}{
+			"alias_name_source":             aliasNameSourceDefault,
+			"max_concurrent_logins":         0,
+			"require_explicit_bindings":     false,
+			"suggest_response_wrapping_ttl": int64(0),
+			"nbf_future_window":             int64(0),
+			"enable_namespace_metadata":     false,
+			"enable_pod_metadata":           false,
+			"token_type":                    logical.TokenTypeDefault.String(),
+		},
+		"bindings_hash": bindingsHash,
 	}
 
 	req := &logical.Request{
@@ -254,6 +812,352 @@ func TestPath_Read(t *testing.T) {
 	}
 }
 
+func TestPath_ReadBindingsHash(t *testing.T) {
+	b, storage := getBackend(t)
+
+	readHash := func(data map[string]interface{}) string {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/plugin-test",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "role/plugin-test",
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data["bindings_hash"].(string)
+	}
+
+	data := map[string]interface{}{
+		"bound_service_account_names":      "vault-auth,app",
+		"bound_service_account_namespaces": "default",
+		"audience":                         "vault",
+		"token_policies":                   "test",
+	}
+	hash := readHash(data)
+	if again := readHash(data); again != hash {
+		t.Fatalf("expected the hash to be stable across reads, got %s and %s", hash, again)
+	}
+
+	// Neither the binding order nor the other fields affect the hash.
+	data["bound_service_account_names"] = "app,vault-auth"
+	data["token_policies"] = "other"
+	if reordered := readHash(data); reordered != hash {
+		t.Fatalf("expected reordered bindings to keep the hash %s, got %s", hash, reordered)
+	}
+
+	for field, value := range map[string]string{
+		"bound_service_account_names":      "app",
+		"bound_service_account_namespaces": "kube-system",
+		"audience":                         "other",
+	} {
+		changed := map[string]interface{}{}
+		for k, v := range data {
+			changed[k] = v
+		}
+		changed[field] = value
+		if got := readHash(changed); got == hash {
+			t.Fatalf("expected changing %s to change the hash", field)
+		}
+	}
+}
+
+func TestPath_ReadAppliedDefaults(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_service_account_names":      "name",
+			"bound_service_account_namespaces": "namespace",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	sys := b.(*kubeAuthBackend).System()
+	expected := map[string]interface{}{
+		"alias_name_source":             aliasNameSourceSAUid,
+		"max_concurrent_logins":         0,
+		"require_explicit_bindings":     false,
+		"suggest_response_wrapping_ttl": int64(0),
+		"nbf_future_window":             int64(0),
+		"enable_namespace_metadata":     false,
+		"enable_pod_metadata":           false,
+		"token_ttl":                     int64(sys.DefaultLeaseTTL().Seconds()),
+		"token_max_ttl":                 int64(sys.MaxLeaseTTL().Seconds()),
+		"token_num_uses":                0,
+		"token_period":                  int64(0),
+		"token_type":                    logical.TokenTypeDefault.String(),
+	}
+	if diff := deep.Equal(expected, resp.Data["applied_defaults"]); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestPath_Export(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_service_account_names":      "name",
+			"bound_service_account_namespaces": "namespace",
+			"audience":                         "vault",
+			"bound_node_names":                 "trusted-*",
+			"metadata_templates":               map[string]interface{}{"workload": "{{ .Namespace }}"},
+			"alias_name_source":                aliasNameSourceSAName,
+			"token_policies":                   "test",
+			"token_bound_cidrs":                "127.0.0.1/8",
+			"token_ttl":                        "1s",
+			"token_max_ttl":                    "5s",
+			"token_num_uses":                   12,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/plugin-test-copy",
+		Storage:   storage,
+		Data:      resp.Data["export"].(map[string]interface{}),
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected, err := b.(*kubeAuthBackend).role(context.Background(), storage, "plugin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := b.(*kubeAuthBackend).role(context.Background(), storage, "plugin-test-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(expected, actual); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestPath_MaxRoles(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"kubernetes_host": "host",
+			"max_roles":       2,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	roleData := map[string]interface{}{
+		"bound_service_account_names":      "name",
+		"bound_service_account_namespaces": "namespace",
+	}
+	writeRole := func(op logical.Operation, name string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data:      roleData,
+		})
+	}
+
+	for _, name := range []string{"role-1", "role-2"} {
+		resp, err := writeRole(logical.CreateOperation, name)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	resp, err = writeRole(logical.CreateOperation, "role-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || resp.Error().Error() != "maximum number of roles (2) reached" {
+		t.Fatalf("expected max roles error, got %#v", resp)
+	}
+
+	// updating an existing role is still allowed
+	resp, err = writeRole(logical.UpdateOperation, "role-1")
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if count := resp.Data["count"]; count != 2 {
+		t.Fatalf("expected a count of 2, got %v", count)
+	}
+}
+
+func TestPath_DeleteByPrefix(t *testing.T) {
+	b, storage := getBackend(t)
+
+	names := []string{"team-a-web", "team-a-api", "team-b-web"}
+	for _, name := range names {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"bound_service_account_names":      "name",
+				"bound_service_account_namespaces": "namespace",
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/delete-by-prefix",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"prefix": "",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an empty prefix to be refused, got %#v", resp)
+	}
+
+	req.Data["prefix"] = "team-a-"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["deleted"], []string{"team-a-api", "team-a-web"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	remaining, err := storage.List(context.Background(), "role/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(remaining, []string{"team-b-web"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestPath_StrictRoleFields(t *testing.T) {
+	b, storage := getBackend(t)
+
+	roleData := map[string]interface{}{
+		"bound_sa_names":                   "name",
+		"bound_service_account_names":      "name",
+		"bound_service_account_namespaces": "namespace",
+	}
+
+	testCases := map[string]struct {
+		strict  bool
+		wantErr string
+	}{
+		"lenient": {},
+		"strict": {
+			strict:  true,
+			wantErr: `unknown field "bound_sa_names"; did you mean "bound_service_account_names"?`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"kubernetes_host":    "host",
+					"strict_role_fields": tc.strict,
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			req = &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "role/plugin-test",
+				Storage:   storage,
+				Data:      roleData,
+			}
+			resp, err = b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr == "" {
+				if resp != nil && resp.IsError() {
+					t.Fatalf("unexpected error response: %#v", resp)
+				}
+				return
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+				t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+			}
+		})
+	}
+}
+
 func TestPath_Delete(t *testing.T) {
 	b, storage := getBackend(t)
 
@@ -311,3 +1215,91 @@ func TestPath_Delete(t *testing.T) {
 		t.Fatalf("Unexpected resp data: expected nil got %#v\n", resp.Data)
 	}
 }
+
+func TestPath_Match(t *testing.T) {
+	b, storage := getBackend(t)
+
+	roles := map[string][2]string{
+		"any":          {"*", "*"},
+		"payments":     {"*", "payments"},
+		"payments-api": {"api", "payments"},
+		"team-prefix":  {"web-*,api", "team-*"},
+		"other":        {"api", "other"},
+	}
+	for name, bindings := range roles {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"bound_service_account_names":      bindings[0],
+				"bound_service_account_namespaces": bindings[1],
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	testCases := map[string]struct {
+		namespace string
+		name      string
+		expected  []string
+		wantErr   string
+	}{
+		"exact bindings": {
+			namespace: "payments",
+			name:      "api",
+			expected:  []string{"any", "payments", "payments-api"},
+		},
+		"glob bindings": {
+			namespace: "team-checkout",
+			name:      "web-frontend",
+			expected:  []string{"any", "team-prefix"},
+		},
+		"only wildcard roles": {
+			namespace: "unknown",
+			name:      "unknown",
+			expected:  []string{"any"},
+		},
+		"missing namespace": {
+			name:    "api",
+			wantErr: "missing namespace",
+		},
+		"missing service account name": {
+			namespace: "payments",
+			wantErr:   "missing service_account_name",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "roles/match",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"namespace":            tc.namespace,
+					"service_account_name": tc.name,
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr != "" {
+				if resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got %#v", tc.wantErr, resp)
+				}
+				return
+			}
+			if resp.IsError() {
+				t.Fatalf("unexpected error response: %#v", resp)
+			}
+			if diff := deep.Equal(resp.Data["roles"], tc.expected); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package kubeauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathRolesStale returns the path reporting the roles found bound to no live
+// namespace by the last role reconciliation.
+func pathRolesStale(b *kubeAuthBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/stale$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRolesStaleRead,
+		},
+		HelpSynopsis:    rolesStaleHelpSyn,
+		HelpDescription: rolesStaleHelpDesc,
+	}
+}
+
+func (b *kubeAuthBackend) pathRolesStaleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.staleRoles(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"roles": []string{},
+			},
+		}
+		resp.AddWarning("roles have not been reconciled yet, set role_reconcile_interval to reconcile them")
+		return resp, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"roles":         entry.Roles,
+			"reconciled_at": entry.ReconciledAt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+const rolesStaleHelpSyn = `Reports the roles bound to no live namespace.`
+const rolesStaleHelpDesc = `
+When role_reconcile_interval is set, the bound namespaces of the roles are
+periodically checked against the namespaces of the cluster. This endpoint
+returns the roles which matched none of them at the last reconciliation. The
+roles are not deleted, they are only reported so that they can be reviewed.
+`
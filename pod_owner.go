@@ -0,0 +1,311 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// podOwnerCacheTTL is how long the controller owning a pod is cached for.
+// Owners rarely change, but a short TTL bounds how long stale ownership is
+// trusted after e.g. a pod is adopted by another controller.
+const podOwnerCacheTTL = 30 * time.Second
+
+// podReader reads the owner references of pods, and of the ReplicaSets owning
+// them, and the nodes pods are scheduled on and their annotations from the
+// kubernetes API.
+type podReader interface {
+	PodOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error)
+	ReplicaSetOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error)
+	PodNodeName(ctx context.Context, namespace, name string) (string, error)
+	PodAnnotations(ctx context.Context, namespace, name string) (map[string]string, error)
+}
+
+type podReaderFactory func(*kubeConfig) podReader
+
+func podAPIFactory(config *kubeConfig) podReader {
+	p := &podAPI{
+		client: cleanhttp.DefaultPooledClient(),
+		config: config,
+	}
+
+	config.configureTransport(p.client.Transport.(*http.Transport))
+
+	return p
+}
+
+type podAPI struct {
+	client *http.Client
+	config *kubeConfig
+}
+
+func (p *podAPI) PodOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error) {
+	return p.ownerReferences(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name))
+}
+
+func (p *podAPI) ReplicaSetOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error) {
+	return p.ownerReferences(ctx, fmt.Sprintf("/apis/apps/v1/namespaces/%s/replicasets/%s", namespace, name))
+}
+
+func (p *podAPI) PodNodeName(ctx context.Context, namespace, name string) (string, error) {
+	pod, err := p.pod(ctx, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	return pod.Spec.NodeName, nil
+}
+
+func (p *podAPI) PodAnnotations(ctx context.Context, namespace, name string) (map[string]string, error) {
+	pod, err := p.pod(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return pod.Annotations, nil
+}
+
+// pod reads the pod name in namespace.
+func (p *podAPI) pod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	body, err := p.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name))
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(body, pod); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into corev1.Pod: %v", err)
+	}
+	return pod, nil
+}
+
+// ownerReferences reads the object at path and returns its owner references.
+func (p *podAPI) ownerReferences(ctx context.Context, path string) ([]metav1.OwnerReference, error) {
+	body, err := p.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var object struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into metav1.ObjectMeta: %v", err)
+	}
+
+	return object.Metadata.OwnerReferences, nil
+}
+
+// get returns the body of the object at path.
+func (p *podAPI) get(ctx context.Context, path string) ([]byte, error) {
+	url := strings.TrimSuffix(p.config.Host, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading pods needs the same access as reading service accounts.
+	token := p.config.SAReadToken
+	if token == "" {
+		token = p.config.TokenReviewerJWT
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetryAfter(ctx, p.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to talk to kubernetes API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read out body: %v", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
+		return nil, kubeerrors.NewGenericServerResponse(resp.StatusCode, http.MethodGet, schema.GroupResource{}, "", strings.TrimSpace(string(body)), 0, true)
+	}
+	return body, nil
+}
+
+// resolvePodOwner returns the controller owning the pod as "Kind/name",
+// resolving ReplicaSets to the Deployment owning them. Pods without a
+// controller have no owner.
+func resolvePodOwner(ctx context.Context, r podReader, namespace, name string) (string, error) {
+	refs, err := r.PodOwnerReferences(ctx, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	owner := controllerOf(refs)
+	if owner == nil {
+		return "", nil
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		refs, err := r.ReplicaSetOwnerReferences(ctx, namespace, owner.Name)
+		if err != nil {
+			return "", err
+		}
+		if deployment := controllerOf(refs); deployment != nil {
+			owner = deployment
+		}
+	}
+
+	return owner.Kind + "/" + owner.Name, nil
+}
+
+// controllerOf returns the managing controller among refs, if any.
+func controllerOf(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// validateOwnerReference checks that ref is of the form "Kind/name".
+func validateOwnerReference(ref string) error {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid owner reference %q, must be of the form Kind/name", ref)
+	}
+	return nil
+}
+
+// podOwnerCache caches the owners resolved by resolvePodOwner, keyed by pod
+// UID so that pods recreated with the same name are resolved again.
+type podOwnerCache struct {
+	l       sync.Mutex
+	entries map[string]cachedPodOwner
+
+	// currentTime is a function that returns the current local time.
+	// Normally set to time.Now but it can be overwritten by test cases to manipulate time.
+	currentTime func() time.Time
+
+	// stats counts the owners served from the cache.
+	stats cacheStats
+}
+
+type cachedPodOwner struct {
+	owner  string
+	expiry time.Time
+}
+
+func newPodOwnerCache(currentTime func() time.Time) *podOwnerCache {
+	return &podOwnerCache{
+		entries:     make(map[string]cachedPodOwner),
+		currentTime: currentTime,
+	}
+}
+
+func (c *podOwnerCache) get(uid string) (string, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	entry, ok := c.entries[uid]
+	if !ok || !c.currentTime().Before(entry.expiry) {
+		c.stats.miss()
+		return "", false
+	}
+	c.stats.hit()
+	return entry.owner, true
+}
+
+func (c *podOwnerCache) set(uid, owner string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	// Drop the expired entries so that the cache doesn't grow with every pod
+	// which ever logged in.
+	now := c.currentTime()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiry) {
+			delete(c.entries, key)
+			c.stats.evict(1)
+		}
+	}
+
+	c.entries[uid] = cachedPodOwner{
+		owner:  owner,
+		expiry: now.Add(podOwnerCacheTTL),
+	}
+}
+
+// Stats returns the lookup counts of the cache, its size is the number of
+// cached owners, including expired ones not evicted yet.
+func (c *podOwnerCache) Stats() map[string]interface{} {
+	c.l.Lock()
+	size := len(c.entries)
+	c.l.Unlock()
+	return c.stats.snapshot(size)
+}
+
+// checkPodOwner denies the login unless the pod the token is bound to is owned
+// by one of the controllers in the role's bound_owner_references.
+func (b *kubeAuthBackend) checkPodOwner(ctx context.Context, config *kubeConfig, role *roleStorageEntry, sa *serviceAccount) error {
+	if sa.Kubernetes == nil || sa.Kubernetes.Pod == nil || sa.Kubernetes.Pod.Name == "" {
+		return newLoginError(http.StatusForbidden, reasonPodClaimMissing, errors.New("token has no pod claim but the role requires bound_owner_references"))
+	}
+	pod := sa.Kubernetes.Pod
+
+	owner, ok := b.podOwners.get(pod.UID)
+	if !ok {
+		var err error
+		owner, err = resolvePodOwner(ctx, b.podReaderFactory(config), sa.namespace(), pod.Name)
+		if kubeerrors.IsNotFound(err) {
+			return newLoginError(http.StatusForbidden, reasonPodNotFound, errors.New("pod or its owner does not exist"))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve pod owner: %v", err)
+		}
+		if pod.UID != "" {
+			b.podOwners.set(pod.UID, owner)
+		}
+	}
+
+	if owner == "" || !strutil.StrListContainsGlob(role.OwnerReferences, owner) {
+		return newLoginError(http.StatusForbidden, reasonOwnerNotAuthorized, errors.New("pod owner not authorized"))
+	}
+	return nil
+}
+
+// podNodeName returns the name of the node the pod the token is bound to is
+// scheduled on, or "" if the token has no pod claim.
+func (b *kubeAuthBackend) podNodeName(ctx context.Context, config *kubeConfig, sa *serviceAccount) (string, error) {
+	if sa.Kubernetes == nil || sa.Kubernetes.Pod == nil || sa.Kubernetes.Pod.Name == "" {
+		return "", nil
+	}
+
+	nodeName, err := b.podReaderFactory(config).PodNodeName(ctx, sa.namespace(), sa.Kubernetes.Pod.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pod node name: %v", err)
+	}
+	return nodeName, nil
+}
+
+// podAnnotations returns the annotations of the pod the token is bound to
+// carrying config.PodAnnotationPrefix, normalised like those of service
+// accounts, or nil if the token has no pod claim.
+func (b *kubeAuthBackend) podAnnotations(ctx context.Context, config *kubeConfig, sa *serviceAccount) (map[string]string, error) {
+	if sa.Kubernetes == nil || sa.Kubernetes.Pod == nil || sa.Kubernetes.Pod.Name == "" {
+		return nil, nil
+	}
+
+	annotations, err := b.podReaderFactory(config).PodAnnotations(ctx, sa.namespace(), sa.Kubernetes.Pod.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod annotations: %v", err)
+	}
+	return normaliseAnnotations(annotations, config.PodAnnotationPrefix, config)
+}
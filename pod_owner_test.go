@@ -0,0 +1,461 @@
+package kubeauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func controllerRef(kind, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{Kind: kind, Name: name, Controller: &controller}
+}
+
+// mockPodReader returns the owner references of the pods and ReplicaSets, and
+// the nodes and annotations of the pods, it was created with, keyed by name,
+// and counts the pods read. Owner references are read with err, if set.
+type mockPodReader struct {
+	pods        map[string][]metav1.OwnerReference
+	replicaSets map[string][]metav1.OwnerReference
+	nodes       map[string]string
+	annotations map[string]map[string]string
+	podReads    *int32
+	err         error
+}
+
+func (m *mockPodReader) PodOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error) {
+	if m.podReads != nil {
+		atomic.AddInt32(m.podReads, 1)
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	refs, ok := m.pods[name]
+	if !ok {
+		return nil, kubeerrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name)
+	}
+	return refs, nil
+}
+
+func (m *mockPodReader) ReplicaSetOwnerReferences(ctx context.Context, namespace, name string) ([]metav1.OwnerReference, error) {
+	refs, ok := m.replicaSets[name]
+	if !ok {
+		return nil, kubeerrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "replicasets"}, name)
+	}
+	return refs, nil
+}
+
+func (m *mockPodReader) PodNodeName(ctx context.Context, namespace, name string) (string, error) {
+	nodeName, ok := m.nodes[name]
+	if !ok {
+		return "", errors.New("pod not found")
+	}
+	return nodeName, nil
+}
+
+func (m *mockPodReader) PodAnnotations(ctx context.Context, namespace, name string) (map[string]string, error) {
+	annotations, ok := m.annotations[name]
+	if !ok {
+		return nil, errors.New("pod not found")
+	}
+	return annotations, nil
+}
+
+func TestResolvePodOwner(t *testing.T) {
+	reader := &mockPodReader{
+		pods: map[string][]metav1.OwnerReference{
+			"deployment-pod":  {controllerRef("ReplicaSet", "payments-api-5d8f")},
+			"replicaset-pod":  {controllerRef("ReplicaSet", "orphan-rs")},
+			"statefulset-pod": {controllerRef("StatefulSet", "db")},
+			"bare-pod":        nil,
+			"adopted-pod":     {{Kind: "ReplicaSet", Name: "not-a-controller"}},
+			"missing-rs-pod":  {controllerRef("ReplicaSet", "missing")},
+		},
+		replicaSets: map[string][]metav1.OwnerReference{
+			"payments-api-5d8f": {controllerRef("Deployment", "payments-api")},
+			"orphan-rs":         nil,
+		},
+	}
+
+	testCases := map[string]struct {
+		pod       string
+		wantOwner string
+		wantErr   bool
+	}{
+		"deployment": {
+			pod:       "deployment-pod",
+			wantOwner: "Deployment/payments-api",
+		},
+		"replicaset without deployment": {
+			pod:       "replicaset-pod",
+			wantOwner: "ReplicaSet/orphan-rs",
+		},
+		"statefulset": {
+			pod:       "statefulset-pod",
+			wantOwner: "StatefulSet/db",
+		},
+		"no owner": {
+			pod: "bare-pod",
+		},
+		"owner which is not the controller": {
+			pod: "adopted-pod",
+		},
+		"missing pod": {
+			pod:     "missing-pod",
+			wantErr: true,
+		},
+		"missing replicaset": {
+			pod:     "missing-rs-pod",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			owner, err := resolvePodOwner(context.Background(), reader, testNamespace, tc.pod)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if owner != tc.wantOwner {
+				t.Fatalf("expected owner %q, got %q", tc.wantOwner, owner)
+			}
+		})
+	}
+}
+
+func TestLoginBoundOwnerReferences(t *testing.T) {
+	config := defaultTestBackendConfig()
+	config.pems = []string{testSigningKeyPEM}
+	config.saName = testProjectedName
+	b, storage := setupBackend(t, config)
+	b.(*kubeAuthBackend).reviewFactory = testProjectedMockFactory
+
+	var podReads int32
+	reader := &mockPodReader{
+		pods: map[string][]metav1.OwnerReference{
+			"payments-api-5d8f-x7k2q": {controllerRef("ReplicaSet", "payments-api-5d8f")},
+			"ledger-0":                {controllerRef("StatefulSet", "ledger")},
+			"debug":                   nil,
+			"orphan-6c9d-p4m8z":       {controllerRef("ReplicaSet", "orphan-6c9d")},
+		},
+		replicaSets: map[string][]metav1.OwnerReference{
+			"payments-api-5d8f": {controllerRef("Deployment", "payments-api")},
+		},
+		podReads: &podReads,
+	}
+	b.(*kubeAuthBackend).podReaderFactory = func(*kubeConfig) podReader { return reader }
+
+	now := time.Now()
+	b.(*kubeAuthBackend).podOwners = newPodOwnerCache(func() time.Time { return now })
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"bound_owner_references": "Deployment/payments-api,StatefulSet/ledger",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	withPod := func(name, uid string) string {
+		claims := testProjectedClaims()
+		claims["kubernetes.io"].(map[string]interface{})["pod"] = map[string]interface{}{
+			"name": name,
+			"uid":  uid,
+		}
+		return signTestJWT(t, claims, nil)
+	}
+	withoutPod := func() string {
+		claims := testProjectedClaims()
+		delete(claims["kubernetes.io"].(map[string]interface{}), "pod")
+		return signTestJWT(t, claims, nil)
+	}
+
+	login := func(jwt string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  jwt,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+	}
+
+	testCases := map[string]struct {
+		jwt        string
+		wantReason string
+	}{
+		"deployment": {
+			jwt: withPod("payments-api-5d8f-x7k2q", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a01"),
+		},
+		"statefulset": {
+			jwt: withPod("ledger-0", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a02"),
+		},
+		"no owner": {
+			jwt:        withPod("debug", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a03"),
+			wantReason: reasonOwnerNotAuthorized,
+		},
+		"no pod claim": {
+			jwt:        withoutPod(),
+			wantReason: reasonPodClaimMissing,
+		},
+		"pod not found": {
+			jwt:        withPod("deleted", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a05"),
+			wantReason: reasonPodNotFound,
+		},
+		"replicaset not found": {
+			jwt:        withPod("orphan-6c9d-p4m8z", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a06"),
+			wantReason: reasonPodNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := login(tc.jwt)
+			if tc.wantReason == "" {
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatalf("err:%s resp:%#v\n", err, resp)
+				}
+				return
+			}
+			if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+				t.Fatalf("expected a 403 coded error, got %#v", err)
+			}
+			if reasonCode(err) != tc.wantReason {
+				t.Fatalf("expected reason %q, got %#v", tc.wantReason, err)
+			}
+		})
+	}
+
+	// The owner of a pod is only read again once the cached one expired.
+	jwt := withPod("payments-api-5d8f-x7k2q", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a04")
+	atomic.StoreInt32(&podReads, 0)
+	for i := 0; i < 2; i++ {
+		if resp, err := login(jwt); err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	if reads := atomic.LoadInt32(&podReads); reads != 1 {
+		t.Fatalf("expected the pod to be read once, got %d", reads)
+	}
+
+	now = now.Add(podOwnerCacheTTL)
+	if resp, err := login(jwt); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if reads := atomic.LoadInt32(&podReads); reads != 2 {
+		t.Fatalf("expected the pod to be read again after the cache expired, got %d", reads)
+	}
+
+	// Failing to reach the kubernetes API is not a denial.
+	reader.err = errors.New("failed to talk to kubernetes API: connection refused")
+	_, err = login(withPod("ledger-0", "1b0c0e2e-9d55-4f3c-8a8e-2f8d8c7f6a07"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := err.(logical.HTTPCodedError); ok {
+		t.Fatalf("expected an uncoded error, got %#v", err)
+	}
+}
+
+func TestLoginPodMetadata(t *testing.T) {
+	config := defaultTestBackendConfig()
+	config.pems = []string{testSigningKeyPEM}
+	config.saName = testProjectedName
+	config.customMetadataFromAnnotations = true
+	b, storage := setupBackend(t, config)
+	b.(*kubeAuthBackend).reviewFactory = testProjectedMockFactory
+	b.(*kubeAuthBackend).serviceAccountReaderFactory = mockServiceAccountReaderFactory(map[string]string{
+		"pod_node_name": "spoofed",
+	})
+	b.(*kubeAuthBackend).podReaderFactory = func(*kubeConfig) podReader {
+		return &mockPodReader{
+			nodes: map[string]string{"vault": "gpu-node-1"},
+		}
+	}
+
+	login := func() map[string]string {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  signTestJWT(t, testProjectedClaims(), nil),
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Auth.Metadata
+	}
+
+	if _, ok := login()["pod_node_name"]; ok {
+		t.Fatal("expected no pod_node_name without enable_pod_metadata")
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/plugin-test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"enable_pod_metadata": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if nodeName := login()["pod_node_name"]; nodeName != "gpu-node-1" {
+		t.Fatalf("expected pod_node_name gpu-node-1, got %q", nodeName)
+	}
+}
+
+func TestLoginPodAnnotationMetadata(t *testing.T) {
+	config := defaultTestBackendConfig()
+	config.pems = []string{testSigningKeyPEM}
+	config.saName = testProjectedName
+	b, storage := setupBackend(t, config)
+	b.(*kubeAuthBackend).reviewFactory = testProjectedMockFactory
+	b.(*kubeAuthBackend).serviceAccountReaderFactory = mockServiceAccountReaderFactory(map[string]string{
+		"team": "sa-team",
+	})
+	b.(*kubeAuthBackend).podReaderFactory = func(*kubeConfig) podReader {
+		return &mockPodReader{
+			annotations: map[string]map[string]string{
+				"vault": {
+					"auth-metadata.vault.hashicorp.com/team":                 "pod-team",
+					"auth-metadata.vault.hashicorp.com/app-version":          "V2",
+					"auth-metadata.vault.hashicorp.com/service-account-name": "spoofed",
+					"example.com/owner": "payments",
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		prefix    string
+		lowercase bool
+		wantToken map[string]string
+		wantErr   string
+	}{
+		"default prefix": {
+			prefix: allowedAnnotationPrefix,
+			wantToken: map[string]string{
+				// The service account annotation takes precedence.
+				"team":                 "sa-team",
+				"app_version":          "V2",
+				"service_account_name": testProjectedName,
+			},
+		},
+		"lowercase values": {
+			prefix:    allowedAnnotationPrefix,
+			lowercase: true,
+			wantToken: map[string]string{
+				"team":                 "sa-team",
+				"app_version":          "v2",
+				"service_account_name": testProjectedName,
+			},
+		},
+		"custom prefix": {
+			prefix: "example.com/",
+			wantToken: map[string]string{
+				"team":                 "sa-team",
+				"owner":                "payments",
+				"service_account_name": testProjectedName,
+			},
+		},
+		"empty prefix": {
+			prefix:  "",
+			wantErr: "pod_annotation_prefix can not be empty",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := map[string]interface{}{
+				"pem_keys":           []string{testSigningKeyPEM},
+				"kubernetes_host":    "host",
+				"kubernetes_ca_cert": testCACert,
+				"enable_custom_metadata_from_annotations": true,
+				"enable_pod_annotation_metadata":          true,
+				"pod_annotation_prefix":                   tc.prefix,
+				"lowercase_annotation_values":             tc.lowercase,
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data:      data,
+			})
+			if tc.wantErr != "" {
+				if err != nil || resp == nil || !resp.IsError() || resp.Error().Error() != tc.wantErr {
+					t.Fatalf("expected error %q, got err:%v resp:%#v", tc.wantErr, err, resp)
+				}
+				return
+			}
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"role": "plugin-test",
+					"jwt":  signTestJWT(t, testProjectedClaims(), nil),
+				},
+				Connection: &logical.Connection{
+					RemoteAddr: "127.0.0.1",
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			for key, want := range tc.wantToken {
+				if got := resp.Auth.Metadata[key]; got != want {
+					t.Fatalf("expected token metadata %s %q, got %#v", key, want, resp.Auth.Metadata)
+				}
+			}
+			for _, key := range []string{"last_applied_configuration", "kubectl.kubernetes.io/last-applied-configuration"} {
+				if _, ok := resp.Auth.Metadata[key]; ok {
+					t.Fatalf("expected no %s in the token metadata, got %#v", key, resp.Auth.Metadata)
+				}
+			}
+
+			// Only the service account annotations are kept on the alias.
+			if resp.Auth.Alias.Metadata["team"] != "sa-team" {
+				t.Fatalf("expected the service account annotation on the alias, got %#v", resp.Auth.Alias.Metadata)
+			}
+			for _, key := range []string{"app_version", "owner"} {
+				if _, ok := resp.Auth.Alias.Metadata[key]; ok {
+					t.Fatalf("expected no pod annotation %s on the alias, got %#v", key, resp.Auth.Alias.Metadata)
+				}
+			}
+		})
+	}
+}
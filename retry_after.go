@@ -0,0 +1,101 @@
+package kubeauth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiMaxRetries is the number of times a request to the kubernetes API is
+	// retried when the apiserver asks for it with a Retry-After header.
+	apiMaxRetries = 2
+
+	// apiMaxRetryAfter bounds the delay honoured for requests without a
+	// deadline, so a misbehaving apiserver can't hold logins indefinitely.
+	apiMaxRetryAfter = 10 * time.Second
+)
+
+// doWithRetryAfter sends req, retrying it when the apiserver responds with
+// 429 Too Many Requests or 503 Service Unavailable and a Retry-After header.
+// The delay requested by the apiserver is honoured unless it would exceed the
+// deadline of ctx, in which case the last response is returned as is.
+func doWithRetryAfter(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		recordAuditID(ctx, resp)
+		if attempt == apiMaxRetries {
+			return resp, nil
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+
+		delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok || !retryAfterAllowed(ctx, delay) {
+			return resp, nil
+		}
+
+		// A request with a body can only be sent again if it can be rewound.
+		next := req.Clone(ctx)
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			next.Body = body
+		}
+		resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		req = next
+	}
+}
+
+// retryAfterAllowed reports whether waiting for delay leaves the request
+// within its deadline, or within apiMaxRetryAfter when it has none.
+func retryAfterAllowed(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Now().Add(delay).Before(deadline)
+	}
+	return delay <= apiMaxRetryAfter
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date, into the delay to wait from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
@@ -0,0 +1,183 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		value     string
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		"seconds": {
+			value:     "3",
+			wantDelay: 3 * time.Second,
+			wantOK:    true,
+		},
+		"http date": {
+			value:     now.Add(5 * time.Second).Format(http.TimeFormat),
+			wantDelay: 5 * time.Second,
+			wantOK:    true,
+		},
+		"http date in the past": {
+			value:  now.Add(-5 * time.Second).Format(http.TimeFormat),
+			wantOK: true,
+		},
+		"empty": {},
+		"negative": {
+			value: "-1",
+		},
+		"invalid": {
+			value: "soon",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			if ok != tc.wantOK || delay != tc.wantDelay {
+				t.Fatalf("expected %v, %t, got %v, %t", tc.wantDelay, tc.wantOK, delay, ok)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryAfter(t *testing.T) {
+	testCases := map[string]struct {
+		status     int
+		retryAfter func() string
+		timeout    time.Duration
+		wantStatus int
+		wantCalls  int32
+		wantDelay  time.Duration
+	}{
+		"too many requests, seconds": {
+			status:     http.StatusTooManyRequests,
+			retryAfter: func() string { return "1" },
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantDelay:  time.Second,
+		},
+		"service unavailable, http date": {
+			status:     http.StatusServiceUnavailable,
+			retryAfter: func() string { return time.Now().Add(2 * time.Second).Format(http.TimeFormat) },
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			// The date has a resolution of a second.
+			wantDelay: time.Second,
+		},
+		"delay past the deadline": {
+			status:     http.StatusTooManyRequests,
+			retryAfter: func() string { return "5" },
+			timeout:    time.Second,
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+		"no retry after": {
+			status:     http.StatusServiceUnavailable,
+			retryAfter: func() string { return "" },
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  1,
+		},
+		"other status": {
+			status:     http.StatusInternalServerError,
+			retryAfter: func() string { return "1" },
+			wantStatus: http.StatusInternalServerError,
+			wantCalls:  1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					if v := tc.retryAfter(); v != "" {
+						w.Header().Set("Retry-After", v)
+					}
+					w.WriteHeader(tc.status)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			resp, err := doWithRetryAfter(ctx, server.Client(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			elapsed := time.Since(start)
+
+			if resp.StatusCode != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, resp.StatusCode)
+			}
+			if calls != tc.wantCalls {
+				t.Fatalf("expected %d calls, got %d", tc.wantCalls, calls)
+			}
+			if elapsed < tc.wantDelay {
+				t.Fatalf("expected a delay of at least %v, got %v", tc.wantDelay, elapsed)
+			}
+		})
+	}
+}
+
+func TestTokenReview_RetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The review must be sent in full on every attempt.
+		review := &authv1.TokenReview{}
+		if err := json.NewDecoder(r.Body).Decode(review); err != nil || review.Spec.Token != jwtData {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{"authenticated":true,"user":{"username":"system:serviceaccount:default:vault-auth","uid":"` + testUID + `"}}}`))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	result, err := tokenReviewAPIFactory(&kubeConfig{Host: server.URL}).Review(context.Background(), jwtData, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected the review to wait for Retry-After, took %v", elapsed)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+	if result.Name != testName || result.UID != testUID {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+}
@@ -0,0 +1,225 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/logical"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// staleRolesPath is the storage path of the result of the last role
+// reconciliation.
+const staleRolesPath = "reconcile/stale_roles"
+
+// namespaceLister lists the namespaces of the cluster.
+type namespaceLister interface {
+	ListNamespaces(ctx context.Context) ([]string, error)
+}
+
+type namespaceListerFactory func(*kubeConfig) namespaceLister
+
+func namespaceAPIFactory(config *kubeConfig) namespaceLister {
+	return newNamespaceAPI(config)
+}
+
+func newNamespaceAPI(config *kubeConfig) *namespaceAPI {
+	n := &namespaceAPI{
+		client: cleanhttp.DefaultPooledClient(),
+		config: config,
+	}
+
+	config.configureTransport(n.client.Transport.(*http.Transport))
+
+	return n
+}
+
+type namespaceAPI struct {
+	client *http.Client
+	config *kubeConfig
+}
+
+func (n *namespaceAPI) ListNamespaces(ctx context.Context) ([]string, error) {
+	body, err := n.get(ctx, "/api/v1/namespaces")
+	if err != nil {
+		return nil, err
+	}
+
+	list := &corev1.NamespaceList{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal into corev1.NamespaceList: %v", err)
+	}
+
+	names := make([]string, len(list.Items))
+	for i, item := range list.Items {
+		names[i] = item.Name
+	}
+	return names, nil
+}
+
+// get returns the body of a GET request of path in the kubernetes API.
+func (n *namespaceAPI) get(ctx context.Context, path string) ([]byte, error) {
+	url := strings.TrimSuffix(n.config.Host, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading namespaces needs the same access as reading service accounts.
+	token := n.config.SAReadToken
+	if token == "" {
+		token = n.config.TokenReviewerJWT
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetryAfter(ctx, n.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to talk to kubernetes API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read out body: %v", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
+		return nil, kubeerrors.NewGenericServerResponse(resp.StatusCode, http.MethodGet, schema.GroupResource{}, "", strings.TrimSpace(string(body)), 0, true)
+	}
+	return body, nil
+}
+
+// staleRolesEntry is the result of a role reconciliation.
+type staleRolesEntry struct {
+	// Roles are the names of the roles whose bound namespaces match none of
+	// the live namespaces.
+	Roles []string `json:"roles"`
+
+	// ReconciledAt is when the roles were reconciled.
+	ReconciledAt time.Time `json:"reconciled_at"`
+}
+
+func (b *kubeAuthBackend) staleRoles(ctx context.Context, s logical.Storage) (*staleRolesEntry, error) {
+	raw, err := s.Get(ctx, staleRolesPath)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	entry := &staleRolesEntry{}
+	if err := raw.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// periodicFunc reconciles the roles once role_reconcile_interval elapsed since
+// the last reconciliation. Nodes with read-only storage skip it, the result of
+// the reconciliation on the primary's active node is replicated to them.
+func (b *kubeAuthBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if b.storageReadOnly() {
+		return nil
+	}
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	// Check the interval against the stored config first, the local token
+	// and CA certificate are only loaded once reconciling.
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.RoleReconcileInterval <= 0 {
+		return nil
+	}
+
+	last, err := b.staleRoles(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if last != nil && now.Sub(last.ReconciledAt) < config.RoleReconcileInterval {
+		return nil
+	}
+
+	config, err = b.loadConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	entry, err := b.reconcileRoles(ctx, req.Storage, config, now)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile roles: %v", err)
+	}
+	if len(entry.Roles) > 0 {
+		b.Logger().Warn("roles are bound to no live namespace", "roles", entry.Roles)
+	}
+
+	storageEntry, err := logical.StorageEntryJSON(staleRolesPath, entry)
+	if err != nil {
+		return err
+	}
+	return req.Storage.Put(ctx, storageEntry)
+}
+
+// reconcileRoles returns the roles whose bound namespaces, globs included,
+// match none of the namespaces of the cluster. Nothing is deleted, the roles
+// are only reported.
+func (b *kubeAuthBackend) reconcileRoles(ctx context.Context, s logical.Storage, config *kubeConfig, now time.Time) (*staleRolesEntry, error) {
+	namespaces, err := b.namespaceListerFactory(config).ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Roles are matched against the part of the namespace following the
+	// tenant on multi-tenant clusters, as at login.
+	if config.NamespacePrefixStrip != "" {
+		for i, namespace := range namespaces {
+			_, namespaces[i] = splitNamespace(config.NamespacePrefixStripRegexp, namespace)
+		}
+	}
+
+	names, err := s.List(ctx, rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	entry := &staleRolesEntry{
+		Roles:        []string{},
+		ReconciledAt: now,
+	}
+	for _, name := range names {
+		role, err := b.role(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+
+		live := false
+		for _, namespace := range namespaces {
+			if role.admitsNamespace(namespace) {
+				live = true
+				break
+			}
+		}
+		if !live {
+			entry.Roles = append(entry.Roles, name)
+		}
+	}
+
+	return entry, nil
+}
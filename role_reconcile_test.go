@@ -0,0 +1,199 @@
+package kubeauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// mockNamespaceLister lists the namespaces it was created with and counts the
+// lists performed.
+type mockNamespaceLister struct {
+	namespaces []string
+	lists      *int
+}
+
+func (m *mockNamespaceLister) ListNamespaces(ctx context.Context) ([]string, error) {
+	*m.lists++
+	return append([]string(nil), m.namespaces...), nil
+}
+
+func TestRoleReconcile(t *testing.T) {
+	b, storage := setupBackend(t, defaultTestBackendConfig())
+
+	var lists int
+	b.(*kubeAuthBackend).namespaceListerFactory = func(*kubeConfig) namespaceLister {
+		return &mockNamespaceLister{
+			namespaces: []string{"default", "team-a", "kube-system"},
+			lists:      &lists,
+		}
+	}
+
+	roles := map[string]string{
+		"live-glob":     "team-*",
+		"dead":          "deleted",
+		"dead-glob":     "gone-*",
+		"partially-set": "deleted,kube-system",
+		"wildcard":      "*",
+	}
+	for name, namespaces := range roles {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"bound_service_account_names":      testName,
+				"bound_service_account_namespaces": namespaces,
+				"policies":                         "test",
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	readStale := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "roles/stale",
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+	periodic := func() {
+		t.Helper()
+		if err := b.(*kubeAuthBackend).periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is reconciled until role_reconcile_interval is set.
+	periodic()
+	if lists != 0 {
+		t.Fatalf("expected no namespace lists, got %d", lists)
+	}
+	if resp := readStale(); len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning, got %#v", resp)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":                testDefaultPEMs,
+			"kubernetes_host":         "host",
+			"kubernetes_ca_cert":      testCACert,
+			"role_reconcile_interval": "1h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	periodic()
+	resp = readStale()
+	if diff := deep.Equal(resp.Data["roles"], []string{"dead", "dead-glob"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.Data["reconciled_at"].(string)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The roles are only reported, never deleted.
+	for _, name := range []string{"dead", "dead-glob"} {
+		role, err := b.(*kubeAuthBackend).role(context.Background(), storage, name)
+		if err != nil || role == nil {
+			t.Fatalf("expected role %s to be kept, got %v", name, err)
+		}
+	}
+
+	// The roles are not reconciled again until the interval elapsed.
+	periodic()
+	if lists != 1 {
+		t.Fatalf("expected a single namespace list, got %d", lists)
+	}
+}
+
+func TestRoleReconcileReadOnlyStorage(t *testing.T) {
+	testCases := map[string]struct {
+		state consts.ReplicationState
+		local bool
+		want  bool
+	}{
+		"performance standby": {
+			state: consts.ReplicationPerformanceStandby,
+		},
+		"performance secondary": {
+			state: consts.ReplicationPerformanceSecondary,
+		},
+		"performance secondary, local mount": {
+			state: consts.ReplicationPerformanceSecondary,
+			local: true,
+			want:  true,
+		},
+		"dr secondary": {
+			state: consts.ReplicationDRSecondary,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			storage := &logical.InmemStorage{}
+			b := Backend()
+			if err := b.Setup(context.Background(), &logical.BackendConfig{
+				Logger: logging.NewVaultLogger(log.Trace),
+				System: &logical.StaticSystemView{
+					ReplicationStateVal: tc.state,
+					LocalMountVal:       tc.local,
+				},
+				StorageView: storage,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			var lists int
+			b.namespaceListerFactory = func(*kubeConfig) namespaceLister {
+				return &mockNamespaceLister{lists: &lists}
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      configPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"pem_keys":                testDefaultPEMs,
+					"kubernetes_host":         "host",
+					"kubernetes_ca_cert":      testCACert,
+					"role_reconcile_interval": "1h",
+				},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+				t.Fatal(err)
+			}
+			entry, err := storage.Get(context.Background(), staleRolesPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reconciled := lists == 1 && entry != nil; reconciled != tc.want {
+				t.Fatalf("expected reconciled %t, got %d lists and entry %#v", tc.want, lists, entry)
+			}
+		})
+	}
+}
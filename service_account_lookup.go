@@ -2,12 +2,11 @@ package kubeauth
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 
 	cleanhttp "github.com/hashicorp/go-cleanhttp"
@@ -19,7 +18,14 @@ import (
 const allowedAnnotationPrefix = "auth-metadata.vault.hashicorp.com/"
 
 type serviceAccountReader interface {
-	ReadAnnotations(ctx context.Context, name, namespace string) (map[string]string, error)
+	ReadAnnotations(ctx context.Context, name, namespace string) (*serviceAccountAnnotations, error)
+}
+
+// serviceAccountAnnotations are the annotations destined for this plugin of a
+// service account, along with the namespace of the object they were read from.
+type serviceAccountAnnotations struct {
+	Namespace   string
+	Annotations map[string]string
 }
 
 type serviceAccountReaderFactory func(*kubeConfig) serviceAccountReader
@@ -30,18 +36,7 @@ func serviceAccountAPIFactory(config *kubeConfig) serviceAccountReader {
 		config: config,
 	}
 
-	// If we have a CA cert build the TLSConfig
-	if len(config.CACert) > 0 {
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM([]byte(config.CACert))
-
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			RootCAs:    certPool,
-		}
-
-		s.client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
-	}
+	config.configureTransport(s.client.Transport.(*http.Transport))
 
 	return s
 }
@@ -51,41 +46,83 @@ type serviceAccountAPI struct {
 	config *kubeConfig
 }
 
-func (s *serviceAccountAPI) ReadAnnotations(ctx context.Context, name, namespace string) (map[string]string, error) {
+func (s *serviceAccountAPI) ReadAnnotations(ctx context.Context, name, namespace string) (*serviceAccountAnnotations, error) {
 	url := fmt.Sprintf("%s/api/v1/namespaces/%s/serviceaccounts/%s", strings.TrimSuffix(s.config.Host, "/"), namespace, name)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	bearer := fmt.Sprintf("Bearer %s", strings.TrimSpace(s.config.TokenReviewerJWT))
+	// Prefer the dedicated token in case the reviewer JWT can't read service
+	// accounts, e.g. as it is scoped to the TokenReview API.
+	token := s.config.SAReadToken
+	if token == "" {
+		token = s.config.TokenReviewerJWT
+	}
+	bearer := fmt.Sprintf("Bearer %s", strings.TrimSpace(token))
 
 	req.Header.Set("Authorization", bearer)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	rsp, err := s.client.Do(req)
+	rsp, err := doWithRetryAfter(ctx, s.client, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to talk to kubernetes API: %v", err)
 	}
 
 	svcAccount, err := parseServiceAccountResponse(rsp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse serviceaccount response: %v", err)
+		return nil, fmt.Errorf("failed to parse serviceaccount response: %w", err)
 	}
 
-	// Filter for annotations that have a prefix and are destined for this plugin
-	filtered := map[string]string{}
-	for key, value := range svcAccount.Annotations {
-		if strings.HasPrefix(key, allowedAnnotationPrefix) {
-			// Normalise the annotations to match the current snake_case pattern.
-			// Ex: auth-metadata.vault.hashicorp.com/service-role: authorization
-			// Will become: service_role: authorization
-			key := strings.ReplaceAll(strings.TrimPrefix(key, allowedAnnotationPrefix), "-", "_")
-			filtered[key] = value
+	filtered, err := normaliseAnnotations(svcAccount.Annotations, allowedAnnotationPrefix, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serviceAccountAnnotations{
+		Namespace:   svcAccount.Namespace,
+		Annotations: filtered,
+	}, nil
+}
+
+// normaliseAnnotations filters the annotations destined for this plugin, those
+// with prefix, and normalises their keys to the snake_case pattern of the
+// metadata, e.g. auth-metadata.vault.hashicorp.com/service-role becomes
+// service_role. The annotations are visited in key order, so that annotations
+// normalising to the same key are resolved deterministically according to the
+// annotation_collision_mode of config. Values are lowercased if config sets
+// lowercase_annotation_values.
+func normaliseAnnotations(annotations map[string]string, prefix string, config *kubeConfig) (map[string]string, error) {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
 		}
 	}
+	sort.Strings(keys)
 
+	filtered := map[string]string{}
+	// origins holds the annotation each normalised key was taken from.
+	origins := map[string]string{}
+	for _, key := range keys {
+		normalised := strings.ReplaceAll(strings.TrimPrefix(key, prefix), "-", "_")
+		if origin, ok := origins[normalised]; ok {
+			switch config.AnnotationCollisionMode {
+			case annotationCollisionFirstWins:
+				continue
+			case annotationCollisionLastWins:
+			default:
+				return nil, fmt.Errorf("annotations %q and %q both normalise to the metadata key %q", origin, key, normalised)
+			}
+		}
+		origins[normalised] = key
+		value := annotations[key]
+		if config.LowercaseAnnotationValues {
+			value = strings.ToLower(value)
+		}
+		filtered[normalised] = value
+	}
 	return filtered, nil
 }
 
@@ -0,0 +1,198 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// currentConfigVersion is the version of the kubeConfig written to storage.
+	// Configs stored before versioning was introduced have version 0.
+	currentConfigVersion = 11
+
+	// currentRoleVersion is the version of the roleStorageEntry written to
+	// storage. Roles stored before versioning was introduced have version 0.
+	currentRoleVersion = 1
+)
+
+// upgradeConfig decodes a stored config, migrating it to currentConfigVersion.
+// It reports whether the config was migrated and should be written back.
+func upgradeConfig(raw []byte) (*kubeConfig, bool, error) {
+	conf := &kubeConfig{}
+	if err := json.Unmarshal(raw, conf); err != nil {
+		return nil, false, err
+	}
+	if conf.Version >= currentConfigVersion {
+		return conf, false, nil
+	}
+
+	// Version 0 to 1: options defaulting to true were introduced without a
+	// version, so configs stored before them rely on the key being absent.
+	if conf.Version < 1 {
+		var stored map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, false, err
+		}
+		if _, ok := stored["allow_default_service_account"]; !ok {
+			conf.AllowDefaultServiceAccount = true
+		}
+		if _, ok := stored["require_service_account_subject"]; !ok {
+			conf.RequireServiceAccountSubject = true
+		}
+	}
+
+	// Version 1 to 2: verification_precedence makes the previous behaviour,
+	// requiring both the signature and the TokenReview to pass, explicit.
+	if conf.Version < 2 {
+		conf.VerificationPrecedence = verificationPrecedenceBothRequired
+	}
+
+	// Version 2 to 3: max_metadata_overflow was introduced.
+	if conf.Version < 3 {
+		conf.MaxMetadataOverflow = metadataOverflowDefault
+	}
+
+	// Version 3 to 4: sa_not_found_metadata_mode was introduced.
+	if conf.Version < 4 {
+		conf.SANotFoundMetadataMode = saNotFoundMetadataDefault
+	}
+
+	// Version 4 to 5: token_review_api_version was introduced.
+	if conf.Version < 5 {
+		conf.TokenReviewAPIVersion = tokenReviewAPIVersionDefault
+	}
+
+	// Version 5 to 6: annotation_collision_mode was introduced.
+	if conf.Version < 6 {
+		conf.AnnotationCollisionMode = annotationCollisionDefault
+	}
+
+	// Version 6 to 7: max_token_audiences was introduced.
+	if conf.Version < 7 {
+		conf.MaxTokenAudiences = defaultMaxTokenAudiences
+	}
+
+	// Version 7 to 8: min_rsa_key_bits was introduced.
+	if conf.Version < 8 {
+		conf.MinRSAKeyBits = defaultMinRSAKeyBits
+	}
+
+	// Version 8 to 9: claim_parse_order was introduced.
+	if conf.Version < 9 {
+		conf.ClaimParseOrder = claimParseOrderDefault
+	}
+
+	// Version 9 to 10: pod_annotation_prefix was introduced.
+	if conf.Version < 10 {
+		conf.PodAnnotationPrefix = allowedAnnotationPrefix
+	}
+
+	// Version 10 to 11: token_review_unreachable_mode was introduced.
+	if conf.Version < 11 {
+		conf.TokenReviewUnreachableMode = tokenReviewUnreachableDefault
+	}
+
+	conf.Version = currentConfigVersion
+	return conf, true, nil
+}
+
+// upgradeRole decodes a stored role, migrating it to currentRoleVersion. It
+// reports whether the role was migrated and should be written back.
+func upgradeRole(raw []byte) (*roleStorageEntry, bool, error) {
+	role := &roleStorageEntry{}
+	if err := json.Unmarshal(raw, role); err != nil {
+		return nil, false, err
+	}
+	if role.Version >= currentRoleVersion {
+		return role, false, nil
+	}
+
+	// Version 0 to 1: roles created before alias_name_source existed have no
+	// source and have always used the service account UID.
+	if role.AliasNameSource == aliasNameSourceUnset {
+		role.AliasNameSource = aliasNameSourceSAUid
+	}
+
+	role.Version = currentRoleVersion
+	return role, true, nil
+}
+
+// initialize migrates the stored config and roles to their current versions.
+// Entries are upgraded when read regardless, writing them back makes the
+// migration explicit and keeps it from depending on the code reading them.
+func (b *kubeAuthBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if b.storageReadOnly() {
+		// The primary performs the migration.
+		return nil
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	raw, err := req.Storage.Get(ctx, configPath)
+	if err != nil {
+		return err
+	}
+	if raw != nil {
+		conf, upgraded, err := upgradeConfig(raw.Value)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade config: %w", err)
+		}
+		if upgraded {
+			entry, err := logical.StorageEntryJSON(configPath, conf)
+			if err != nil {
+				return err
+			}
+			if err := req.Storage.Put(ctx, entry); err != nil {
+				return err
+			}
+			b.Logger().Info("upgraded config", "version", conf.Version)
+		}
+	}
+
+	roles, err := req.Storage.List(ctx, rolePrefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range roles {
+		raw, err := req.Storage.Get(ctx, rolePrefix+name)
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			continue
+		}
+
+		role, upgraded, err := upgradeRole(raw.Value)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade role %q: %w", name, err)
+		}
+		if !upgraded {
+			continue
+		}
+
+		entry, err := logical.StorageEntryJSON(rolePrefix+name, role)
+		if err != nil {
+			return err
+		}
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			return err
+		}
+		b.Logger().Info("upgraded role", "role", name, "version", role.Version)
+	}
+
+	return nil
+}
+
+// storageReadOnly reports whether the storage of the mount is read-only on
+// this node, as on DR secondaries, performance standbys and, unless the mount
+// is local, performance secondaries.
+func (b *kubeAuthBackend) storageReadOnly() bool {
+	replicationState := b.System().ReplicationState()
+	return (!b.System().LocalMount() && replicationState.HasState(consts.ReplicationPerformanceSecondary)) ||
+		replicationState.HasState(consts.ReplicationDRSecondary|consts.ReplicationPerformanceStandby)
+}
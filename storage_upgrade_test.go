@@ -0,0 +1,203 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestInitialize_UpgradesStorage(t *testing.T) {
+	testCases := map[string]struct {
+		config      string
+		role        string
+		wantConfig  kubeConfig
+		wantRoleSrc string
+	}{
+		"pre-versioning entries": {
+			config: `{"host":"host","pem_keys":[]}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"]}`,
+			wantConfig: kubeConfig{
+				Host:                         "host",
+				AllowDefaultServiceAccount:   true,
+				RequireServiceAccountSubject: true,
+				VerificationPrecedence:       verificationPrecedenceBothRequired,
+				SANotFoundMetadataMode:       saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:        tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:      annotationCollisionDefault,
+				MaxTokenAudiences:            defaultMaxTokenAudiences,
+				MinRSAKeyBits:                defaultMinRSAKeyBits,
+				ClaimParseOrder:              claimParseOrderDefault,
+				PodAnnotationPrefix:          allowedAnnotationPrefix,
+				TokenReviewUnreachableMode:   tokenReviewUnreachableDefault,
+				Version:                      currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceSAUid,
+		},
+		"pre-versioning entries with explicit values": {
+			config: `{"host":"host","pem_keys":[],"allow_default_service_account":false,"require_service_account_subject":false}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"alias_name_source":"serviceaccount_name"}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceBothRequired,
+				SANotFoundMetadataMode:     saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:    annotationCollisionDefault,
+				MaxTokenAudiences:          defaultMaxTokenAudiences,
+				MinRSAKeyBits:              defaultMinRSAKeyBits,
+				ClaimParseOrder:            claimParseOrderDefault,
+				PodAnnotationPrefix:        allowedAnnotationPrefix,
+				TokenReviewUnreachableMode: tokenReviewUnreachableDefault,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceSAName,
+		},
+		"version 1 config": {
+			config: `{"host":"host","pem_keys":[],"version":1}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"version":1}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceBothRequired,
+				SANotFoundMetadataMode:     saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:    annotationCollisionDefault,
+				MaxTokenAudiences:          defaultMaxTokenAudiences,
+				MinRSAKeyBits:              defaultMinRSAKeyBits,
+				ClaimParseOrder:            claimParseOrderDefault,
+				PodAnnotationPrefix:        allowedAnnotationPrefix,
+				TokenReviewUnreachableMode: tokenReviewUnreachableDefault,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceUnset,
+		},
+		"version 3 config": {
+			config: `{"host":"host","pem_keys":[],"verification_precedence":"review_wins","max_metadata_overflow":"fail","version":3}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"version":1}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceReviewWins,
+				SANotFoundMetadataMode:     saNotFoundMetadataDefault,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:    annotationCollisionDefault,
+				MaxTokenAudiences:          defaultMaxTokenAudiences,
+				MinRSAKeyBits:              defaultMinRSAKeyBits,
+				ClaimParseOrder:            claimParseOrderDefault,
+				PodAnnotationPrefix:        allowedAnnotationPrefix,
+				TokenReviewUnreachableMode: tokenReviewUnreachableDefault,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceUnset,
+		},
+		"version 4 config": {
+			config: `{"host":"host","pem_keys":[],"verification_precedence":"review_wins","max_metadata_overflow":"fail","sa_not_found_metadata_mode":"ignore","version":4}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"version":1}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceReviewWins,
+				SANotFoundMetadataMode:     saNotFoundMetadataIgnore,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionDefault,
+				AnnotationCollisionMode:    annotationCollisionDefault,
+				MaxTokenAudiences:          defaultMaxTokenAudiences,
+				MinRSAKeyBits:              defaultMinRSAKeyBits,
+				ClaimParseOrder:            claimParseOrderDefault,
+				PodAnnotationPrefix:        allowedAnnotationPrefix,
+				TokenReviewUnreachableMode: tokenReviewUnreachableDefault,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceUnset,
+		},
+		"version 6 config": {
+			config: `{"host":"host","pem_keys":[],"verification_precedence":"review_wins","max_metadata_overflow":"fail","sa_not_found_metadata_mode":"ignore","token_review_api_version":"v1beta1","annotation_collision_mode":"last_wins","version":6}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"version":1}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceReviewWins,
+				SANotFoundMetadataMode:     saNotFoundMetadataIgnore,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionV1beta1,
+				AnnotationCollisionMode:    annotationCollisionLastWins,
+				MaxTokenAudiences:          defaultMaxTokenAudiences,
+				MinRSAKeyBits:              defaultMinRSAKeyBits,
+				ClaimParseOrder:            claimParseOrderDefault,
+				PodAnnotationPrefix:        allowedAnnotationPrefix,
+				TokenReviewUnreachableMode: tokenReviewUnreachableDefault,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceUnset,
+		},
+		"current entries": {
+			config: `{"host":"host","pem_keys":[],"verification_precedence":"review_wins","max_metadata_overflow":"fail","sa_not_found_metadata_mode":"ignore","token_review_api_version":"v1beta1","annotation_collision_mode":"last_wins","max_token_audiences":8,"min_rsa_key_bits":4096,"claim_parse_order":"legacy_first","pod_annotation_prefix":"example.com/","token_review_unreachable_mode":"fail_open","version":11}`,
+			role:   `{"bound_service_account_names":["vault-auth"],"bound_service_account_namespaces":["default"],"version":1}`,
+			wantConfig: kubeConfig{
+				Host:                       "host",
+				VerificationPrecedence:     verificationPrecedenceReviewWins,
+				SANotFoundMetadataMode:     saNotFoundMetadataIgnore,
+				TokenReviewAPIVersion:      tokenReviewAPIVersionV1beta1,
+				AnnotationCollisionMode:    annotationCollisionLastWins,
+				MaxTokenAudiences:          8,
+				MinRSAKeyBits:              4096,
+				ClaimParseOrder:            claimParseOrderLegacyFirst,
+				PodAnnotationPrefix:        "example.com/",
+				TokenReviewUnreachableMode: tokenReviewUnreachableFailOpen,
+				Version:                    currentConfigVersion,
+			},
+			wantRoleSrc: aliasNameSourceUnset,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			b, storage := getBackend(t)
+			ctx := context.Background()
+
+			for key, value := range map[string]string{configPath: tc.config, rolePrefix + "old": tc.role} {
+				if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(value)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := storage.Get(ctx, configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var conf kubeConfig
+			if err := json.Unmarshal(raw.Value, &conf); err != nil {
+				t.Fatal(err)
+			}
+			if conf.Host != tc.wantConfig.Host ||
+				conf.AllowDefaultServiceAccount != tc.wantConfig.AllowDefaultServiceAccount ||
+				conf.RequireServiceAccountSubject != tc.wantConfig.RequireServiceAccountSubject ||
+				conf.VerificationPrecedence != tc.wantConfig.VerificationPrecedence ||
+				conf.SANotFoundMetadataMode != tc.wantConfig.SANotFoundMetadataMode ||
+				conf.TokenReviewAPIVersion != tc.wantConfig.TokenReviewAPIVersion ||
+				conf.AnnotationCollisionMode != tc.wantConfig.AnnotationCollisionMode ||
+				conf.MaxTokenAudiences != tc.wantConfig.MaxTokenAudiences ||
+				conf.MinRSAKeyBits != tc.wantConfig.MinRSAKeyBits ||
+				conf.ClaimParseOrder != tc.wantConfig.ClaimParseOrder ||
+				conf.PodAnnotationPrefix != tc.wantConfig.PodAnnotationPrefix ||
+				conf.TokenReviewUnreachableMode != tc.wantConfig.TokenReviewUnreachableMode ||
+				conf.Version != tc.wantConfig.Version {
+				t.Fatalf("unexpected stored config: %#v", conf)
+			}
+
+			raw, err = storage.Get(ctx, rolePrefix+"old")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var role roleStorageEntry
+			if err := json.Unmarshal(raw.Value, &role); err != nil {
+				t.Fatal(err)
+			}
+			if role.AliasNameSource != tc.wantRoleSrc || role.Version != currentRoleVersion {
+				t.Fatalf("unexpected stored role: %#v", role)
+			}
+			if len(role.ServiceAccountNames) != 1 || role.ServiceAccountNames[0] != "vault-auth" {
+				t.Fatalf("role bindings were not preserved: %#v", role)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package kubeauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenAddrCacheMaxEntries bounds the number of tokens bound to a remote
+// address, the bindings expiring first are dropped beyond it.
+const tokenAddrCacheMaxEntries = 10000
+
+// tokenAddrCache binds projected tokens to the remote address they were first
+// used from until they expire, keyed by the SHA-256 hash of the token so that
+// the tokens themselves are not kept in memory.
+type tokenAddrCache struct {
+	l          sync.Mutex
+	entries    map[string]boundTokenAddr
+	maxEntries int
+
+	// currentTime is a function that returns the current local time.
+	// Normally set to time.Now but it can be overwritten by test cases to manipulate time.
+	currentTime func() time.Time
+
+	// stats counts the tokens found bound.
+	stats cacheStats
+}
+
+type boundTokenAddr struct {
+	addr   string
+	expiry time.Time
+}
+
+func newTokenAddrCache(currentTime func() time.Time, maxEntries int) *tokenAddrCache {
+	return &tokenAddrCache{
+		entries:     make(map[string]boundTokenAddr),
+		maxEntries:  maxEntries,
+		currentTime: currentTime,
+	}
+}
+
+// bind binds the token to addr until expiry unless it is already bound, and
+// reports whether the token is bound to addr.
+func (c *tokenAddrCache) bind(token, addr string, expiry time.Time) bool {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	now := c.currentTime()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expiry) {
+		c.stats.hit()
+		return entry.addr == addr
+	}
+	c.stats.miss()
+
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = boundTokenAddr{
+		addr:   addr,
+		expiry: expiry,
+	}
+	return true
+}
+
+// evict drops the expired bindings, or the one expiring first if none has
+// expired. It must be called with l held.
+func (c *tokenAddrCache) evict(now time.Time) {
+	var first string
+	evicted := 0
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiry) {
+			delete(c.entries, key)
+			evicted++
+			continue
+		}
+		if first == "" || entry.expiry.Before(c.entries[first].expiry) {
+			first = key
+		}
+	}
+	if evicted == 0 && first != "" {
+		delete(c.entries, first)
+		evicted++
+	}
+	c.stats.evict(evicted)
+}
+
+// Stats returns the lookup counts of the cache, its size is the number of
+// bound tokens, including expired ones not evicted yet.
+func (c *tokenAddrCache) Stats() map[string]interface{} {
+	c.l.Lock()
+	size := len(c.entries)
+	c.l.Unlock()
+	return c.stats.snapshot(size)
+}
+
+// checkTokenAddr denies the login if the projected token was first used from
+// another remote address. Tokens without an expiry are not bound, as the
+// binding would be kept forever.
+func (b *kubeAuthBackend) checkTokenAddr(jwtStr, remoteAddr string, sa *serviceAccount) error {
+	if sa.Kubernetes == nil || sa.Expiration == 0 || remoteAddr == "" {
+		return nil
+	}
+	if !b.tokenAddrs.bind(jwtStr, remoteAddr, time.Unix(sa.Expiration, 0)) {
+		return newLoginError(http.StatusForbidden, reasonTokenAddressMismatch, errors.New("token used from new address"))
+	}
+	return nil
+}
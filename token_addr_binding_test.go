@@ -0,0 +1,100 @@
+package kubeauth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTokenAddrCache(t *testing.T) {
+	now := time.Now()
+	c := newTokenAddrCache(func() time.Time { return now }, 2)
+
+	if !c.bind("token-1", "10.0.0.1", now.Add(time.Minute)) {
+		t.Fatal("expected the first use to bind the token")
+	}
+	if !c.bind("token-1", "10.0.0.1", now.Add(time.Minute)) {
+		t.Fatal("expected the token to be used from its address")
+	}
+	if c.bind("token-1", "10.0.0.2", now.Add(time.Minute)) {
+		t.Fatal("expected the token to be denied from another address")
+	}
+
+	// The binding expiring first is dropped once the cache is full.
+	c.bind("token-2", "10.0.0.2", now.Add(2*time.Minute))
+	c.bind("token-3", "10.0.0.3", now.Add(3*time.Minute))
+	if len(c.entries) != 2 {
+		t.Fatalf("expected 2 bindings, got %d", len(c.entries))
+	}
+	if !c.bind("token-1", "10.0.0.2", now.Add(time.Minute)) {
+		t.Fatal("expected the evicted token to be bound again")
+	}
+
+	// Expired bindings are released.
+	now = now.Add(2 * time.Minute)
+	if !c.bind("token-2", "10.0.0.4", now.Add(time.Minute)) {
+		t.Fatal("expected the expired token to be bound again")
+	}
+}
+
+func TestLoginBindTokenToRemoteAddr(t *testing.T) {
+	config := defaultTestBackendConfig()
+	config.pems = []string{testSigningKeyPEM}
+	config.saName = testProjectedName
+	b, storage := setupBackend(t, config)
+	b.(*kubeAuthBackend).reviewFactory = testProjectedMockFactory
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      configPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"pem_keys":                  []string{testSigningKeyPEM},
+			"kubernetes_host":           "host",
+			"kubernetes_ca_cert":        testCACert,
+			"bind_token_to_remote_addr": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	claims := testProjectedClaims()
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	jwt := signTestJWT(t, claims, nil)
+
+	login := func(remoteAddr string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"role": "plugin-test",
+				"jwt":  jwt,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: remoteAddr,
+			},
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp, err := login("127.0.0.1"); err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	resp, err = login("127.0.0.2")
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusForbidden {
+		t.Fatalf("expected a 403 coded error, got %#v", err)
+	}
+	if err.Error() != `token used from new address for role "plugin-test" (reason_code: TOKEN_ADDRESS_MISMATCH)` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if reasonCode(err) != reasonTokenAddressMismatch {
+		t.Fatalf("expected reason %q, got %#v", reasonTokenAddressMismatch, err)
+	}
+}
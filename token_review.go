@@ -3,8 +3,6 @@ package kubeauth
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,9 +20,10 @@ import (
 
 // This is the result from the token review
 type tokenReviewResult struct {
-	Name      string
-	Namespace string
-	UID       string
+	Name          string
+	Namespace     string
+	UID           string
+	Authenticated bool
 }
 
 // This exists so we can use a mock TokenReview when running tests
@@ -34,6 +33,30 @@ type tokenReviewer interface {
 
 type tokenReviewFactory func(*kubeConfig) tokenReviewer
 
+var (
+	// errTokenReviewAudienceMismatch is returned when the apiserver rejects the
+	// token because none of its audiences were accepted.
+	errTokenReviewAudienceMismatch = errors.New("token audience not accepted by apiserver")
+
+	// errTokenReviewTokenExpired is returned when the apiserver rejects the
+	// token because it expired.
+	errTokenReviewTokenExpired = errors.New("token expired according to apiserver")
+
+	// errTokenReviewNotFound is returned when the apiserver rejects the token
+	// because its service account, or the secret or pod it is bound to, no
+	// longer exists.
+	errTokenReviewNotFound = errors.New("object bound to the token not found by apiserver")
+
+	// errTokenReviewerUnauthorized is returned when the apiserver refuses the
+	// TokenReview itself because the configured token_reviewer_jwt is invalid
+	// or lacks the permission to create TokenReviews.
+	errTokenReviewerUnauthorized = errors.New("token reviewer not authorized to perform TokenReviews")
+
+	// errTokenReviewUnreachable is returned when the kubernetes API can't be
+	// reached, or fails to process the TokenReview, e.g. during an outage.
+	errTokenReviewUnreachable = errors.New("kubernetes API unreachable to perform TokenReviews")
+)
+
 // This is the real implementation that calls the kubernetes API
 type tokenReviewAPI struct {
 	config *kubeConfig
@@ -49,21 +72,20 @@ func (t *tokenReviewAPI) Review(ctx context.Context, jwt string, aud []string) (
 
 	client := cleanhttp.DefaultClient()
 
-	// If we have a CA cert build the TLSConfig
-	if len(t.config.CACert) > 0 {
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM([]byte(t.config.CACert))
-
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			RootCAs:    certPool,
-		}
-
-		client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
-	}
+	t.config.configureTransport(client.Transport.(*http.Transport))
 
 	// Create the TokenReview Object and marshal it into json
+	version := t.config.TokenReviewAPIVersion
+	if version == "" {
+		version = tokenReviewAPIVersionDefault
+	}
+	// The v1beta1 TokenReview has the same schema as v1, only the apiVersion
+	// differs.
 	trReq := &authv1.TokenReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "authentication.k8s.io/" + version,
+			Kind:       "TokenReview",
+		},
 		Spec: authv1.TokenReviewSpec{
 			Token:     jwt,
 			Audiences: aud,
@@ -75,7 +97,7 @@ func (t *tokenReviewAPI) Review(ctx context.Context, jwt string, aud []string) (
 	}
 
 	// Build the request to the token review API
-	url := fmt.Sprintf("%s/apis/authentication.k8s.io/v1/tokenreviews", strings.TrimSuffix(t.config.Host, "/"))
+	url := fmt.Sprintf("%s/apis/authentication.k8s.io/%s/tokenreviews", strings.TrimSuffix(t.config.Host, "/"), version)
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(trJSON))
 	if err != nil {
 		return nil, err
@@ -96,34 +118,60 @@ func (t *tokenReviewAPI) Review(ctx context.Context, jwt string, aud []string) (
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := doWithRetryAfter(ctx, client, req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("lookup failed: %w: %v", errTokenReviewUnreachable, err)
 	}
 
 	// Parse the resp into a tokenreview object or a kubernetes error type
 	r, err := parseResponse(resp)
 	switch {
+	case kubeerrors.IsForbidden(err):
+		return nil, fmt.Errorf("lookup failed: %w: %v", errTokenReviewerUnauthorized, err)
+	case kubeerrors.IsUnauthorized(err) && len(t.config.TokenReviewerJWT) > 0:
+		return nil, fmt.Errorf("lookup failed: %w: %v", errTokenReviewerUnauthorized, err)
 	case kubeerrors.IsUnauthorized(err):
 		// If the err is unauthorized that means the token has since been deleted;
 		// this can happen if the service account is deleted, and even if it has
 		// since been recreated the token will have changed, which means our
 		// caller will need to be updated accordingly.
 		return nil, errors.New("lookup failed: service account unauthorized; this could mean it has been deleted or recreated with a new token")
+	case isServerError(err):
+		return nil, fmt.Errorf("lookup failed: %w: %v", errTokenReviewUnreachable, err)
 	case err != nil:
 		return nil, err
 	}
 
-	if r.Status.Error != "" {
-		return nil, fmt.Errorf("lookup failed: %s", r.Status.Error)
+	return tokenReviewResultFromStatus(r.Status)
+}
+
+// tokenReviewResultFromStatus validates the status of a TokenReview and
+// extracts the service account it was performed for.
+func tokenReviewResultFromStatus(status authv1.TokenReviewStatus) (*tokenReviewResult, error) {
+	if status.Error != "" {
+		// The apiserver reports the audiences of the token not intersecting
+		// with the requested ones as e.g.:
+		// [invalid bearer token, token audiences ["foo"] is invalid for the target audiences ["bar"]]
+		if strings.Contains(status.Error, "is invalid for the target audiences") {
+			return nil, fmt.Errorf("lookup failed: %w: %s", errTokenReviewAudienceMismatch, status.Error)
+		}
+		// [invalid bearer token, service account token has expired]
+		if strings.Contains(strings.ToLower(status.Error), "has expired") {
+			return nil, fmt.Errorf("lookup failed: %w: %s", errTokenReviewTokenExpired, status.Error)
+		}
+		// [invalid bearer token, service account default/vault-auth has been deleted]
+		if strings.Contains(status.Error, "has been deleted") || strings.Contains(status.Error, "not found") {
+			return nil, fmt.Errorf("lookup failed: %w: %s", errTokenReviewNotFound, status.Error)
+		}
+		return nil, fmt.Errorf("lookup failed: %s", status.Error)
 	}
 
-	if !r.Status.Authenticated {
+	if !status.Authenticated {
 		return nil, errors.New("lookup failed: service account jwt not valid")
 	}
 
 	// The username is of format: system:serviceaccount:(NAMESPACE):(SERVICEACCOUNT)
-	parts := strings.Split(r.Status.User.Username, ":")
+	parts := strings.Split(status.User.Username, ":")
 	if len(parts) != 4 {
 		return nil, errors.New("lookup failed: unexpected username format")
 	}
@@ -134,12 +182,20 @@ func (t *tokenReviewAPI) Review(ctx context.Context, jwt string, aud []string) (
 	}
 
 	return &tokenReviewResult{
-		Name:      parts[3],
-		Namespace: parts[2],
-		UID:       string(r.Status.User.UID),
+		Name:          parts[3],
+		Namespace:     parts[2],
+		UID:           string(status.User.UID),
+		Authenticated: status.Authenticated,
 	}, nil
 }
 
+// isServerError reports whether err is a kubernetes API error with a 5xx
+// status.
+func isServerError(err error) bool {
+	var status kubeerrors.APIStatus
+	return errors.As(err, &status) && status.Status().Code >= http.StatusInternalServerError
+}
+
 // parseResponse takes the API response and either returns the appropriate error
 // or the TokenReview Object.
 func parseResponse(resp *http.Response) (*authv1.TokenReview, error) {
@@ -149,8 +205,16 @@ func parseResponse(resp *http.Response) (*authv1.TokenReview, error) {
 	}
 	defer resp.Body.Close()
 
-	// If the request was not a success create a kuberenets error
+	// If the request was not a success create a kuberenets error, preferring
+	// the Status returned by the apiserver as it carries the reason.
 	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
+		errStatus := &metav1.Status{}
+		if err := json.Unmarshal(body, errStatus); err == nil && errStatus.Kind == "Status" {
+			if errStatus.Code == 0 {
+				errStatus.Code = int32(resp.StatusCode)
+			}
+			return nil, kubeerrors.FromObject(runtime.Object(errStatus))
+		}
 		return nil, kubeerrors.NewGenericServerResponse(resp.StatusCode, "POST", schema.GroupResource{}, "", strings.TrimSpace(string(body)), 0, true)
 	}
 
@@ -195,8 +259,9 @@ func (t *mockTokenReview) Review(ctx context.Context, cjwt string, aud []string)
 	}
 
 	return &tokenReviewResult{
-		Name:      t.saName,
-		Namespace: t.saNamespace,
-		UID:       t.saUID,
+		Name:          t.saName,
+		Namespace:     t.saNamespace,
+		UID:           t.saUID,
+		Authenticated: true,
 	}, nil
 }
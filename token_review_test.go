@@ -0,0 +1,171 @@
+package kubeauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+func TestTokenReviewErrors(t *testing.T) {
+	testCases := map[string]struct {
+		status      int
+		body        string
+		reviewerJWT string
+		wantErr     error
+	}{
+		"reviewer forbidden": {
+			status:      http.StatusForbidden,
+			body:        `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"tokenreviews.authentication.k8s.io is forbidden: User \"system:serviceaccount:vault:vault\" cannot create resource \"tokenreviews\"","reason":"Forbidden","code":403}`,
+			reviewerJWT: "reviewer-jwt",
+			wantErr:     errTokenReviewerUnauthorized,
+		},
+		"reviewer unauthorized": {
+			status:      http.StatusUnauthorized,
+			body:        `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"Unauthorized","reason":"Unauthorized","code":401}`,
+			reviewerJWT: "reviewer-jwt",
+			wantErr:     errTokenReviewerUnauthorized,
+		},
+		// Without a reviewer JWT the token under review is the bearer.
+		"token unauthorized": {
+			status: http.StatusUnauthorized,
+			body:   `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"Unauthorized","reason":"Unauthorized","code":401}`,
+		},
+		"server error": {
+			status:      http.StatusInternalServerError,
+			body:        "internal error",
+			reviewerJWT: "reviewer-jwt",
+			wantErr:     errTokenReviewUnreachable,
+		},
+		"service unavailable": {
+			status:      http.StatusServiceUnavailable,
+			body:        `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"etcdserver: request timed out","reason":"ServiceUnavailable","code":503}`,
+			reviewerJWT: "reviewer-jwt",
+			wantErr:     errTokenReviewUnreachable,
+		},
+		"token expired": {
+			status:  http.StatusCreated,
+			body:    `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","status":{"authenticated":false,"error":"[invalid bearer token, service account token has expired]"}}`,
+			wantErr: errTokenReviewTokenExpired,
+		},
+		"pod deleted": {
+			status:  http.StatusCreated,
+			body:    `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","status":{"authenticated":false,"error":"[invalid bearer token, pod default/vault-agent has been deleted]"}}`,
+			wantErr: errTokenReviewNotFound,
+		},
+		"audience mismatch": {
+			status:  http.StatusCreated,
+			body:    `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","status":{"authenticated":false,"error":"[invalid bearer token, token audiences [\"vault\"] is invalid for the target audiences [\"kubernetes.default.svc\"]]"}}`,
+			wantErr: errTokenReviewAudienceMismatch,
+		},
+	}
+
+	sentinels := []error{errTokenReviewAudienceMismatch, errTokenReviewTokenExpired, errTokenReviewNotFound, errTokenReviewerUnauthorized, errTokenReviewUnreachable}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			config := &kubeConfig{
+				Host:             server.URL,
+				TokenReviewerJWT: tc.reviewerJWT,
+			}
+			_, err := tokenReviewAPIFactory(config).Review(context.Background(), jwtData, nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			for _, sentinel := range sentinels {
+				if errors.Is(err, sentinel) != (sentinel == tc.wantErr) {
+					t.Fatalf("unexpected error %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenReviewConnectionRefused(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	config := &kubeConfig{
+		Host:             server.URL,
+		TokenReviewerJWT: "reviewer-jwt",
+	}
+	_, err := tokenReviewAPIFactory(config).Review(context.Background(), jwtData, nil)
+	if !errors.Is(err, errTokenReviewUnreachable) {
+		t.Fatalf("expected the kubernetes API to be unreachable, got %v", err)
+	}
+}
+
+func TestTokenReviewAPIVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version  string
+		wantPath string
+		want     string
+	}{
+		"unset": {
+			wantPath: "/apis/authentication.k8s.io/v1/tokenreviews",
+			want:     "authentication.k8s.io/v1",
+		},
+		"v1": {
+			version:  tokenReviewAPIVersionV1,
+			wantPath: "/apis/authentication.k8s.io/v1/tokenreviews",
+			want:     "authentication.k8s.io/v1",
+		},
+		"v1beta1": {
+			version:  tokenReviewAPIVersionV1beta1,
+			wantPath: "/apis/authentication.k8s.io/v1beta1/tokenreviews",
+			want:     "authentication.k8s.io/v1beta1",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var path string
+			review := &authv1.TokenReview{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				review.Status = authv1.TokenReviewStatus{
+					Authenticated: true,
+					User: authv1.UserInfo{
+						Username: serviceAccountSubjectPrefix + testNamespace + ":" + testName,
+						UID:      testUID,
+					},
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(review)
+			}))
+			defer server.Close()
+
+			config := &kubeConfig{
+				Host:                  server.URL,
+				TokenReviewAPIVersion: tc.version,
+			}
+			if _, err := tokenReviewAPIFactory(config).Review(context.Background(), jwtData, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if path != tc.wantPath {
+				t.Fatalf("expected the review to be posted to %q, got %q", tc.wantPath, path)
+			}
+			if review.APIVersion != tc.want || review.Kind != "TokenReview" {
+				t.Fatalf("expected apiVersion %q, got %q of kind %q", tc.want, review.APIVersion, review.Kind)
+			}
+		})
+	}
+}
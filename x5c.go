@@ -0,0 +1,118 @@
+package kubeauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/briankassouf/jose/jws"
+)
+
+var errEmbeddedCertMismatch = errors.New("embedded certificate does not match the configured pem_keys")
+
+// verifyEmbeddedCerts checks the x5c, x5t and x5t#S256 headers of the JWT,
+// when present, against the configured keys. The leaf certificate of an x5c
+// chain must hold one of the public keys of pem_keys or pem_keys_dir. The
+// thumbprints must be those of one of the certificates of pem_keys, as only
+// the public keys of the files in pem_keys_dir are kept.
+func verifyEmbeddedCerts(jwtStr string, config *kubeConfig) error {
+	parsedJWS, err := jws.Parse([]byte(jwtStr))
+	if err != nil {
+		return jwtValidationError(err)
+	}
+	headers := parsedJWS.Protected()
+
+	if headers.Has("x5c") {
+		leaf, err := x5cLeaf(headers.Get("x5c"))
+		if err != nil {
+			return newLoginError(http.StatusForbidden, reasonEmbeddedCertMismatch, err)
+		}
+		if !containsPublicKey(config.PublicKeys, leaf.PublicKey) {
+			return newLoginError(http.StatusForbidden, reasonEmbeddedCertMismatch, errEmbeddedCertMismatch)
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, pemKey := range config.PEMKeys {
+		certs = append(certs, parseCertificatesPEM([]byte(pemKey))...)
+	}
+	thumbprints := []struct {
+		header string
+		sum    func([]byte) []byte
+	}{
+		{"x5t", func(b []byte) []byte { s := sha1.Sum(b); return s[:] }},
+		{"x5t#S256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }},
+	}
+	for _, t := range thumbprints {
+		if !headers.Has(t.header) {
+			continue
+		}
+		value, ok := headers.Get(t.header).(string)
+		if !ok {
+			return newLoginError(http.StatusForbidden, reasonEmbeddedCertMismatch, fmt.Errorf("%s header must be a string", t.header))
+		}
+		want, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil {
+			return newLoginError(http.StatusForbidden, reasonEmbeddedCertMismatch, fmt.Errorf("failed to decode %s header: %v", t.header, err))
+		}
+
+		matched := false
+		for _, cert := range certs {
+			if bytes.Equal(t.sum(cert.Raw), want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return newLoginError(http.StatusForbidden, reasonEmbeddedCertMismatch, errEmbeddedCertMismatch)
+		}
+	}
+
+	return nil
+}
+
+// x5cLeaf returns the first, leaf, certificate of an x5c header.
+func x5cLeaf(x5c interface{}) (*x509.Certificate, error) {
+	var first interface{}
+	switch chain := x5c.(type) {
+	case []interface{}:
+		if len(chain) > 0 {
+			first = chain[0]
+		}
+	case []string:
+		if len(chain) > 0 {
+			first = chain[0]
+		}
+	}
+	encoded, ok := first.(string)
+	if !ok {
+		return nil, errors.New("x5c header must be a non-empty list of certificates")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x5c header: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse x5c header: %v", err)
+	}
+	return cert, nil
+}
+
+// containsPublicKey reports whether key is among keys.
+func containsPublicKey(keys []interface{}, key crypto.PublicKey) bool {
+	for _, k := range keys {
+		if e, ok := k.(interface{ Equal(crypto.PublicKey) bool }); ok && e.Equal(key) {
+			return true
+		}
+	}
+	return false
+}